@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/dirigera"
+)
+
+// Exit codes of the check command, 2 is left out because it is used for usage errors by the flag package and for
+// unknown commands
+const (
+	checkOK                  = 0
+	checkFailed              = 1
+	checkConnectivityFailed  = 3
+	checkTLSFailed           = 4
+	checkAuthorizationFailed = 5
+)
+
+// runCheck verifies the connection to the hubs and exits with a status code indicating the failed step.
+// If several hubs are checked, the status code of the first failed hub is returned.
+func runCheck(args []string) int {
+	flags := newFlagSet("check")
+	configFile := configFlag(flags)
+	hubName := hubFlag(flags)
+	_ = flags.Parse(args)
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return checkFailed
+	}
+	hubs, err := selectHubs(cfg, *hubName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return checkFailed
+	}
+
+	result := checkOK
+	for _, hub := range hubs {
+		if status := checkHub(hub); result == checkOK {
+			result = status
+		}
+	}
+	return result
+}
+
+func checkHub(cfg config.HubConfig) int {
+	hub, err := dirigera.CheckHub(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Check of %s failed: %v\n", cfg.Label(), err)
+		switch {
+		case errors.Is(err, dirigera.ErrConnectivity):
+			return checkConnectivityFailed
+		case errors.Is(err, dirigera.ErrTLS):
+			return checkTLSFailed
+		case errors.Is(err, dirigera.ErrAuthorization):
+			return checkAuthorizationFailed
+		default:
+			return checkFailed
+		}
+	}
+
+	fmt.Printf("✅ Hub %s (%s) reachable at %s:%d\n", hub.Name, hub.ID, hub.Address, hub.Port)
+	fmt.Printf("   Firmware version: %s\n", hub.FirmwareVersion)
+	fmt.Printf("   TLS fingerprint:  %s\n", hub.TLSFingerprint)
+	return checkOK
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/salex-org/ikea-dirigera-exporter/internal/dirigera"
+)
+
+// runDevices lists all devices reported by the hubs as table or JSON
+func runDevices(args []string) int {
+	flags := newFlagSet("devices")
+	configFile := configFlag(flags)
+	hubName := hubFlag(flags)
+	output := flags.String("output", "table", "output format (table or json)")
+	_ = flags.Parse(args)
+
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q, expected table or json\n", *output)
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	hubs, err := selectHubs(cfg, *hubName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	var devices []dirigera.DeviceInfo
+	for _, hub := range hubs {
+		hubDevices, err := dirigera.ListDevices(hub)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", hub.Label(), err)
+			return 1
+		}
+		for i := range hubDevices {
+			if len(hubs) > 1 {
+				hubDevices[i].Hub = hub.Label()
+			}
+		}
+		devices = append(devices, hubDevices...)
+	}
+
+	if *output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(devices); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding devices: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if len(hubs) > 1 {
+		_, _ = fmt.Fprint(writer, "HUB\t")
+	}
+	_, _ = fmt.Fprintln(writer, "ID\tTYPE\tROOM\tNAME\tREACHABLE\tATTRIBUTES")
+	for _, device := range devices {
+		if len(hubs) > 1 {
+			_, _ = fmt.Fprintf(writer, "%s\t", device.Hub)
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%t\t%s\n", device.ID, device.DetailedType, device.RoomName, device.Name, device.IsReachable, formatAttributes(device.Attributes))
+	}
+	_ = writer.Flush()
+	return 0
+}
+
+// formatAttributes formats the attributes sorted by name as comma separated key=value pairs
+func formatAttributes(attributes map[string]interface{}) string {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, attributes[key]))
+	}
+	return strings.Join(pairs, ", ")
+}
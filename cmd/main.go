@@ -1,114 +1,103 @@
 package main
 
 import (
-	"context"
-	_ "embed"
+	"flag"
 	"fmt"
-	"log"
 	"os"
-	"os/signal"
-	"sync"
-	"syscall"
-	"time"
+	"path/filepath"
+	"strings"
+	_ "time/tzdata" // embedded timezone database as fallback because the distroless image has no zoneinfo
 
-	"github.com/salex-org/ikea-dirigera-exporter/internal/dirigera"
-	"github.com/salex-org/ikea-dirigera-exporter/internal/util"
-	"github.com/salex-org/ikea-dirigera-exporter/internal/webserver"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
 )
 
-var (
-	dirigeraClient dirigera.DirigeraClient
-	webServer      webserver.Server
+type command struct {
+	name        string
+	description string
+	run         func(args []string) int
+}
 
-	//go:embed assets/ascii.art
-	asciiArt string
-)
+var commands = []command{
+	{name: "serve", description: "Start the exporter and serve the metrics (default)", run: runServe},
+	{name: "devices", description: "List all devices reported by the hub", run: runDevices},
+	{name: "pair", description: "Pair with the hub to obtain an access token and the TLS fingerprint", run: runPair},
+	{name: "check", description: "Verify connectivity, TLS fingerprint and access token of the hub", run: runCheck},
+	{name: "version", description: "Print the version of the exporter", run: runVersion},
+}
 
 func main() {
-	// Startup function
-	fmt.Printf("%s\n\n", fmt.Sprintf(asciiArt, util.Version))
-	err := startup()
-	if err != nil {
-		log.Fatalf("Error during startup: %v\n", err)
-	}
-
-	// Notification context for reacting on process termination - used by shutdown function
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
+	args := os.Args[1:]
 
-	// Waiting group used to await finishing the shutdown process when stopping
-	var wait sync.WaitGroup
-
-	// Loop function for webserver
-	wait.Add(1)
-	go func() {
-		defer wait.Done()
-		fmt.Printf("Web server started\n")
-		_ = webServer.Start()
-	}()
-
-	// Loop function for event listening
-	wait.Add(1)
-	go func() {
-		defer wait.Done()
-		fmt.Printf("IKEA dirigera client started\n")
-		_ = dirigeraClient.Start()
-	}()
+	// Without command or with flags only the exporter is started to stay compatible with existing deployments
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" && args[0] != "--help" {
+		os.Exit(runServe(args))
+	}
 
-	// Shutdown function waiting for the SIGTERM notification to stop event listening
-	wait.Add(1)
-	go func() {
-		defer wait.Done()
-		<-ctx.Done()
-		fmt.Printf("\n\U0001F6D1 Shutdown down started...\n")
-		shutdown()
-	}()
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			os.Exit(cmd.run(args[1:]))
+		}
+	}
 
-	wait.Wait()
-	fmt.Printf("\U0001F3C1 Shutdown finished\n")
-	os.Exit(0)
+	if args[0] == "help" || strings.HasPrefix(args[0], "-") {
+		printUsage()
+		os.Exit(0)
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+	printUsage()
+	os.Exit(2)
 }
 
-func startup() error {
-	var err error
-	time.Local, err = time.LoadLocation("CET")
-	if err != nil {
-		return fmt.Errorf("error pinning location: %w", err)
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
 	}
-	fmt.Printf("Timezone CET loaded\n")
-
-	webServer = webserver.NewServer(healthCheck)
-	fmt.Printf("Web server created\n")
+	fmt.Fprintf(os.Stderr, "\nUse '%s <command> -h' to show the flags of a command.\n", filepath.Base(os.Args[0]))
+}
 
-	dirigeraClient, err = dirigera.NewDirigeraClient()
-	if err != nil {
-		return fmt.Errorf("error creationg IKEA dirigera client: %w", err)
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags]\n\nFlags:\n", filepath.Base(os.Args[0]), name)
+		flags.PrintDefaults()
 	}
-	fmt.Printf("IKEA dirigera client created for hub %s\n", dirigeraClient.GetHubName())
+	return flags
+}
 
-	return nil
+func configFlag(flags *flag.FlagSet) *string {
+	return flags.String("config", os.Getenv(config.FileEnvVar), "path of the YAML configuration file (env: "+config.FileEnvVar+")")
 }
 
-func shutdown() {
-	err := dirigeraClient.Shutdown()
-	if err != nil {
-		fmt.Printf("Error stopping event listening: %v\n", err)
-	} else {
-		fmt.Printf("Event listening stopped\n")
-	}
+func hubFlag(flags *flag.FlagSet) *string {
+	return flags.String("hub", "", "name of the hub if several hubs are configured (default all hubs)")
+}
 
-	err = webServer.Shutdown()
-	if err != nil {
-		fmt.Printf("Error stopping web server: %v\n", err)
-	} else {
-		fmt.Printf("Web server stopped\n")
+// selectHubs returns the configurations of all hubs or only of the hub with the given name if not empty
+func selectHubs(cfg *config.Config, name string) ([]config.HubConfig, error) {
+	hubs := cfg.HubConfigs()
+	if len(hubs) == 0 {
+		return nil, fmt.Errorf("no hub configured")
+	}
+	if name == "" {
+		return hubs, nil
 	}
+	for _, hub := range hubs {
+		if hub.Label() == name {
+			return []config.HubConfig{hub}, nil
+		}
+	}
+	return nil, fmt.Errorf("no hub named %q configured", name)
 }
 
-func healthCheck() map[string]error {
-	errors := make(map[string]error)
-	if err := dirigeraClient.Health(); err != nil {
-		errors["IKEA DIRIGERA Client"] = err
+// loadConfig loads and validates the configuration
+func loadConfig(configFile string) (*config.Config, error) {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading configuration: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
 	}
-	return errors
+	return cfg, nil
 }
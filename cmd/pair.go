@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/dirigera"
+)
+
+// runPair pairs the exporter with the hub and prints or writes the access token and the TLS fingerprint
+func runPair(args []string) int {
+	flags := newFlagSet("pair")
+	configFile := configFlag(flags)
+	hubName := hubFlag(flags)
+	address := flags.String("address", "", "address of the hub (default from configuration)")
+	port := flags.Int("port", 0, "port of the hub (default from configuration)")
+	clientName := flags.String("name", "ikea-dirigera-exporter", "name of the client registered in the hub")
+	tokenFile := flags.String("token-file", "", "write the access token to this file instead of printing it")
+	fingerprintFile := flags.String("fingerprint-file", "", "write the TLS fingerprint to this file instead of printing it")
+	_ = flags.Parse(args)
+
+	// Token and fingerprint are not yet known, so the configuration is only used for address and port
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		return 1
+	}
+	hubs, err := selectHubs(cfg, *hubName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if len(hubs) > 1 {
+		fmt.Fprintf(os.Stderr, "Several hubs configured, use -hub to select the hub to pair with\n")
+		return 2
+	}
+	hub := hubs[0]
+	if *address == "" {
+		*address = hub.Address
+	}
+	if *port == 0 {
+		*port = hub.Port
+	}
+	if *address == "" {
+		fmt.Fprintf(os.Stderr, "No hub address configured, searching via mDNS...\n")
+		discoveredAddress, discoveredPort, err := dirigera.DiscoverHub(hub.Discovery)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\nUse -address or IKEA_ADDRESS to specify the hub\n", err)
+			return 1
+		}
+		*address = discoveredAddress
+		if discoveredPort != 0 {
+			*port = discoveredPort
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Pairing with hub %s:%d...\n", *address, *port)
+	pairing, err := dirigera.Pair(*address, *port, *clientName,
+		func() { fmt.Fprintf(os.Stderr, "Press the action button on the bottom of the hub within 60 seconds ") },
+		func() { fmt.Fprintf(os.Stderr, ".") },
+	)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "✅ Paired as %s\n", *clientName)
+
+	if err := writeOrPrint("IKEA_TOKEN", pairing.AccessToken, *tokenFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing access token: %v\n", err)
+		return 1
+	}
+	if err := writeOrPrint("IKEA_TLS_FINGERPRINT", pairing.TLSFingerprint, *fingerprintFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing TLS fingerprint: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// writeOrPrint writes the value to the file if a path is given, otherwise it is printed as environment variable
+func writeOrPrint(name, value, path string) error {
+	if path == "" {
+		fmt.Printf("%s=%s\n", name, value)
+		return nil
+	}
+	if err := os.WriteFile(path, []byte(value+"\n"), 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s written to %s\n", name, path)
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/dirigera"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/webserver"
+)
+
+var (
+	probeModulesMutex sync.RWMutex
+	probeModules      map[string]config.ModuleConfig // key: module name
+)
+
+// setProbeModules replaces the modules used for probing, e.g. after reloading the configuration
+func setProbeModules(modules map[string]config.ModuleConfig) {
+	probeModulesMutex.Lock()
+	defer probeModulesMutex.Unlock()
+
+	probeModules = modules
+}
+
+// probe probes the hub at the target with the settings of the module
+func probe(target, module string, registerer prometheus.Registerer) error {
+	probeModulesMutex.RLock()
+	moduleCfg, found := probeModules[module]
+	probeModulesMutex.RUnlock()
+	if !found {
+		return fmt.Errorf("%w %q", webserver.ErrUnknownModule, module)
+	}
+	return dirigera.Probe(target, moduleCfg, registerer)
+}
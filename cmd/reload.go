@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
+)
+
+// reloadMetric contains the metrics about reloading the configuration
+type reloadMetric struct {
+	lastReloadSuccessfulMetric prometheus.Gauge
+	lastReloadSuccessMetric    prometheus.Gauge
+}
+
+func newReloadMetric() *reloadMetric {
+	metric := &reloadMetric{
+		lastReloadSuccessfulMetric: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "config_last_reload_successful",
+			Help:      "Result of the last configuration reload (0 = failed, 1 = successful)",
+		}),
+		lastReloadSuccessMetric: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Last time the configuration was loaded successfully (Unix timestamp in seconds)",
+		}),
+	}
+	prometheus.MustRegister(metric.lastReloadSuccessfulMetric)
+	prometheus.MustRegister(metric.lastReloadSuccessMetric)
+
+	return metric
+}
+
+func (m *reloadMetric) update(err error) {
+	if err != nil {
+		m.lastReloadSuccessfulMetric.Set(0)
+		return
+	}
+	m.lastReloadSuccessfulMetric.Set(1)
+	m.lastReloadSuccessMetric.SetToCurrentTime()
+}
+
+var (
+	reloadMutex   sync.Mutex
+	reloadMetrics *reloadMetric
+	configFile    string
+	serverPort    int
+	timezone      string
+	hubLabels     []string // labels of the hub clients in the order of the configuration
+)
+
+// reload reads the configuration again and applies it to the running exporter.
+// The web server keeps running, a changed server port or timezone or added and removed hubs require a restart.
+// The hubs keep the labels used at startup.
+func reload() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	err := applyReload()
+	reloadMetrics.update(err)
+	if err != nil {
+		fmt.Printf("Error reloading configuration: %v\n", err)
+		return err
+	}
+	fmt.Printf("Configuration reloaded\n")
+	return nil
+}
+
+func applyReload() error {
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if cfg.Server.Port != serverPort {
+		fmt.Printf("Warning: Changing the server port from %d to %d requires a restart\n", serverPort, cfg.Server.Port)
+	}
+	// The timezone is not changed while running, time.Local is used concurrently by all goroutines
+	if cfg.Timezone != timezone {
+		fmt.Printf("Warning: Changing the timezone from %q to %q requires a restart\n", timezone, cfg.Timezone)
+	}
+
+	setProbeModules(cfg.Modules)
+	hubConfigs := cfg.HubConfigs()
+	matched := make(map[string]bool) // key: label of the hub client
+	reconfigure := func(label string, hubCfg config.HubConfig) bool {
+		dirigeraClient, exists := dirigeraClients[label]
+		if !exists || matched[label] {
+			return false
+		}
+		matched[label] = true
+		dirigeraClient.Reconfigure(hubCfg)
+		return true
+	}
+	// Hubs with name are matched by name first, hubs without name by their position, because the label derived
+	// from the address changes with it
+	var unnamed []int
+	for i, hubCfg := range hubConfigs {
+		if hubCfg.Name == "" || !reconfigure(hubCfg.Name, hubCfg) {
+			unnamed = append(unnamed, i)
+		}
+	}
+	for _, i := range unnamed {
+		hubCfg := hubConfigs[i]
+		if hubCfg.Name != "" || i >= len(hubLabels) || !reconfigure(hubLabels[i], hubCfg) {
+			fmt.Printf("Warning: Adding the hub %s requires a restart\n", hubCfg.Label())
+		}
+	}
+	for _, label := range hubLabels {
+		if !matched[label] {
+			fmt.Printf("Warning: Removing the hub %s requires a restart\n", label)
+		}
+	}
+	return nil
+}
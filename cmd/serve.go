@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/dirigera"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/util"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/webserver"
+)
+
+var (
+	dirigeraClients map[string]dirigera.DirigeraClient // key: label of the hub configuration
+	webServer       webserver.Server
+
+	//go:embed assets/ascii.art
+	asciiArt string
+)
+
+// runServe starts the exporter and serves the metrics until the process is terminated
+func runServe(args []string) int {
+	flags := newFlagSet("serve")
+	configPath := configFlag(flags)
+	_ = flags.Parse(args)
+	configFile = *configPath
+
+	// Startup function
+	fmt.Printf("%s\n\n", fmt.Sprintf(asciiArt, util.Version))
+	err := startup()
+	if err != nil {
+		log.Fatalf("Error during startup: %v\n", err)
+	}
+
+	// Notification context for reacting on process termination - used by shutdown function
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Waiting group used to await finishing the shutdown process when stopping
+	var wait sync.WaitGroup
+
+	// Loop function for webserver
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		fmt.Printf("Web server started\n")
+		_ = webServer.Start()
+	}()
+
+	// Loop functions for event listening, one per hub
+	for label, dirigeraClient := range dirigeraClients {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			fmt.Printf("IKEA dirigera client for %s started\n", label)
+			_ = dirigeraClient.Start()
+		}()
+	}
+
+	// Reload function waiting for SIGHUP notifications to reload the configuration
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
+	defer signal.Stop(reloadSignal)
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for {
+			select {
+			case <-reloadSignal:
+				fmt.Printf("Reloading configuration after SIGHUP\n")
+				_ = reload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Shutdown function waiting for the SIGTERM notification to stop event listening
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		<-ctx.Done()
+		fmt.Printf("\n\U0001F6D1 Shutdown down started...\n")
+		shutdown()
+	}()
+
+	wait.Wait()
+	fmt.Printf("\U0001F3C1 Shutdown finished\n")
+	return 0
+}
+
+func startup() error {
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if configFile != "" {
+		fmt.Printf("Configuration loaded from %s\n", configFile)
+	} else {
+		fmt.Printf("Configuration loaded from environment\n")
+	}
+
+	time.Local, err = cfg.Location()
+	if err != nil {
+		return fmt.Errorf("error loading timezone: %w", err)
+	}
+	zoneName, _ := time.Now().Zone()
+	fmt.Printf("Timezone %s (%s) loaded\n", time.Local, zoneName)
+
+	serverPort = cfg.Server.Port
+	timezone = cfg.Timezone
+	reloadMetrics = newReloadMetric()
+	reloadMetrics.update(nil)
+	setProbeModules(cfg.Modules)
+	webServer = webserver.NewServer(cfg.Server, healthCheck, reload, probe)
+	fmt.Printf("Web server created\n")
+
+	metrics := dirigera.NewMetrics(prometheus.DefaultRegisterer)
+	dirigeraClients = make(map[string]dirigera.DirigeraClient)
+	for _, hubCfg := range cfg.HubConfigs() {
+		dirigeraClients[hubCfg.Label()] = dirigera.NewDirigeraClient(hubCfg, metrics)
+		hubLabels = append(hubLabels, hubCfg.Label())
+		fmt.Printf("IKEA dirigera client created for hub %s\n", hubCfg.Label())
+	}
+
+	return nil
+}
+
+func shutdown() {
+	for label, dirigeraClient := range dirigeraClients {
+		err := dirigeraClient.Shutdown()
+		if err != nil {
+			fmt.Printf("Error stopping event listening for %s: %v\n", label, err)
+		} else {
+			fmt.Printf("Event listening for %s stopped\n", label)
+		}
+	}
+
+	err := webServer.Shutdown()
+	if err != nil {
+		fmt.Printf("Error stopping web server: %v\n", err)
+	} else {
+		fmt.Printf("Web server stopped\n")
+	}
+}
+
+func healthCheck() map[string]error {
+	errors := make(map[string]error)
+	for label, dirigeraClient := range dirigeraClients {
+		if err := dirigeraClient.Health(); err != nil {
+			errors[healthKey(label)] = err
+		}
+	}
+	return errors
+}
+
+// healthKey returns the name of the health check entry for the hub, which is distinguished by the label
+// only if several hubs are configured
+func healthKey(label string) string {
+	if len(dirigeraClients) == 1 {
+		return "IKEA DIRIGERA Client"
+	}
+	return fmt.Sprintf("IKEA DIRIGERA Client %s", label)
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/salex-org/ikea-dirigera-exporter/internal/util"
+)
+
+// runVersion prints the version of the exporter
+func runVersion(args []string) int {
+	_ = newFlagSet("version").Parse(args)
+	fmt.Printf("ikea-dirigera-exporter %s\n", util.Version)
+	return 0
+}
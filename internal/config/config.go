@@ -0,0 +1,429 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+)
+
+const (
+	DefaultHubPort        = 8443
+	DefaultServerPort     = 9100
+	DefaultProbeTimeout   = 10 * time.Second
+	DefaultResyncInterval = 5 * time.Minute
+
+	// minResyncInterval and minKeepAliveInterval protect the hub from being flooded with requests
+	minResyncInterval    = 10 * time.Second
+	minKeepAliveInterval = 10 * time.Second
+
+	// FileEnvVar names the environment variable that can be used instead of the command line flag to
+	// specify the path of the configuration file
+	FileEnvVar = "IKEA_CONFIG_FILE"
+)
+
+// Config contains the complete configuration of the exporter
+type Config struct {
+	// Hub configures a single hub, Hubs several hubs served by one exporter - only one of both can be used.
+	// The environment variables only apply to Hub.
+	Hub    HubConfig    `yaml:"hub"`
+	Hubs   []HubConfig  `yaml:"hubs"`
+	Server ServerConfig `yaml:"server"`
+
+	// Modules contains the settings for probing hubs via the /probe endpoint, key: module name.
+	// With modules only, no hub needs to be configured.
+	Modules map[string]ModuleConfig `yaml:"modules"`
+
+	// Timezone is the IANA name of the timezone used for all time based features, system timezone if empty
+	Timezone string `yaml:"timezone"`
+}
+
+// HubConfig contains the settings for connecting to an IKEA DIRIGERA hub
+type HubConfig struct {
+	// Name identifies the hub in the log and the health check, defaults to the address or discovery criteria
+	Name string `yaml:"name"`
+
+	Address        string `yaml:"address"` // discovered via mDNS if empty
+	Port           int    `yaml:"port"`
+	Token          string `yaml:"token"`
+	TLSFingerprint string `yaml:"tls_fingerprint"`
+
+	// TokenFile and TLSFingerprintFile are read instead of Token and TLSFingerprint, e.g. from secret mounts.
+	// The files are watched, changes are applied without restart.
+	TokenFile          string `yaml:"token_file"`
+	TLSFingerprintFile string `yaml:"tls_fingerprint_file"`
+
+	// TrustOnFirstUse enables recording the fingerprint of the hub certificate in the state file on first connect
+	// instead of configuring it. The state file also keeps the firmware versions and the energy offsets of the
+	// devices between restarts.
+	TrustOnFirstUse bool   `yaml:"trust_on_first_use"`
+	StateFile       string `yaml:"state_file"`
+
+	// Discovery selects the hub found via mDNS if no address is configured
+	Discovery DiscoveryConfig `yaml:"discovery"`
+
+	// ResyncInterval is the interval for loading all devices again to correct values missed by the event loop
+	ResyncInterval time.Duration `yaml:"resync_interval"`
+
+	// EventSilenceThreshold is the time without events after which the hub is reported as degraded, 0 disables
+	// the check. KeepAliveInterval is the interval for requesting the hub status while no events are received,
+	// with it a silent event connection is also established again. 0 disables the keep-alive probes.
+	EventSilenceThreshold time.Duration `yaml:"event_silence_threshold"`
+	KeepAliveInterval     time.Duration `yaml:"keep_alive_interval"`
+}
+
+// DiscoveryConfig contains the criteria for selecting a hub found via mDNS.
+// Without criteria exactly one hub must be found in the network.
+type DiscoveryConfig struct {
+	ID   string `yaml:"id"`   // serial number of the hub, equals the hub ID without suffix
+	Name string `yaml:"name"` // host name of the hub
+}
+
+// ModuleConfig contains the settings for probing hubs via the /probe endpoint, the address of the hub is
+// given by the target of the probe
+type ModuleConfig struct {
+	Port               int           `yaml:"port"` // used if the target contains no port
+	Token              string        `yaml:"token"`
+	TokenFile          string        `yaml:"token_file"`
+	TLSFingerprint     string        `yaml:"tls_fingerprint"`
+	TLSFingerprintFile string        `yaml:"tls_fingerprint_file"`
+	Timeout            time.Duration `yaml:"timeout"`
+}
+
+// ServerConfig contains the settings of the web server providing the metrics
+type ServerConfig struct {
+	Port int `yaml:"port"`
+}
+
+// Load creates a configuration with default values, overwrites them with the values from the
+// configuration file (if path is not empty) and finally applies the overrides from environment variables.
+// The configuration is not validated, call Validate before using it.
+func Load(path string) (*Config, error) {
+	cfg := &Config{
+		Hub: defaultHubConfig(),
+		Server: ServerConfig{
+			Port: DefaultServerPort,
+		},
+	}
+
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading configuration file: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+			return nil, fmt.Errorf("error parsing configuration file %s: %w", path, err)
+		}
+		for i := range cfg.Hubs {
+			if cfg.Hubs[i].Port == 0 {
+				cfg.Hubs[i].Port = DefaultHubPort
+			}
+			if cfg.Hubs[i].ResyncInterval == 0 {
+				cfg.Hubs[i].ResyncInterval = DefaultResyncInterval
+			}
+		}
+		for name, module := range cfg.Modules {
+			if module.Port == 0 {
+				module.Port = DefaultHubPort
+			}
+			if module.Timeout == 0 {
+				module.Timeout = DefaultProbeTimeout
+			}
+			cfg.Modules[name] = module
+		}
+	}
+
+	if err := cfg.applyEnvironment(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func defaultHubConfig() HubConfig {
+	return HubConfig{
+		Port:           DefaultHubPort,
+		ResyncInterval: DefaultResyncInterval,
+	}
+}
+
+// applyEnvironment overwrites the configuration values with the values of the environment variables if present
+func (c *Config) applyEnvironment() error {
+	var errs []error
+	lookupString("IKEA_ADDRESS", &c.Hub.Address)
+	lookupString("IKEA_TOKEN", &c.Hub.Token)
+	lookupString("IKEA_TLS_FINGERPRINT", &c.Hub.TLSFingerprint)
+	lookupString("IKEA_TOKEN_FILE", &c.Hub.TokenFile)
+	lookupString("IKEA_TLS_FINGERPRINT_FILE", &c.Hub.TLSFingerprintFile)
+	lookupString("IKEA_STATE_FILE", &c.Hub.StateFile)
+	lookupString("IKEA_HUB_ID", &c.Hub.Discovery.ID)
+	lookupString("IKEA_HUB_NAME", &c.Hub.Discovery.Name)
+	lookupString("IKEA_TIMEZONE", &c.Timezone)
+	errs = append(errs, lookupInt("IKEA_PORT", &c.Hub.Port))
+	errs = append(errs, lookupBool("IKEA_TRUST_ON_FIRST_USE", &c.Hub.TrustOnFirstUse))
+	errs = append(errs, lookupDuration("IKEA_RESYNC_INTERVAL", &c.Hub.ResyncInterval))
+	errs = append(errs, lookupDuration("IKEA_EVENT_SILENCE_THRESHOLD", &c.Hub.EventSilenceThreshold))
+	errs = append(errs, lookupDuration("IKEA_KEEP_ALIVE_INTERVAL", &c.Hub.KeepAliveInterval))
+	errs = append(errs, lookupInt("IKEA_SERVER_PORT", &c.Server.Port))
+	return errors.Join(errs...)
+}
+
+// Validate checks the configuration and returns an error describing every invalid value
+func (c *Config) Validate() error {
+	var errs []error
+	switch {
+	case len(c.Hubs) > 0:
+		errs = append(errs, c.validateHubs()...)
+	case !c.probeOnly():
+		errs = append(errs, c.Hub.validate("hub")...)
+		errs = append(errs, c.Hub.validateServed("hub")...)
+	}
+	names := make([]string, 0, len(c.Modules))
+	for name := range c.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		module := c.Modules[name]
+		prefix := fmt.Sprintf("modules.%s", name)
+		hub := module.HubConfig("", module.Port)
+		errs = append(errs, hub.validate(prefix)...)
+		if module.Timeout <= 0 {
+			errs = append(errs, fmt.Errorf("%s.timeout: must be positive, got %s", prefix, module.Timeout))
+		}
+	}
+	errs = append(errs, validatePort("server.port", c.Server.Port))
+	if _, err := c.Location(); err != nil {
+		errs = append(errs, fmt.Errorf("timezone: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// systemLocation is the system timezone, captured before time.Local is replaced by the configured timezone
+var systemLocation = time.Local
+
+// Location returns the configured timezone or the system timezone if none is configured.
+// The system timezone is UTC if the system has no timezone information, e.g. in distroless images.
+func (c *Config) Location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return systemLocation, nil
+	}
+	location, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown IANA timezone %q", c.Timezone)
+	}
+	return location, nil
+}
+
+// HubConfigs returns the configurations of all hubs, either the list of hubs or the single hub.
+// The result is empty if only modules for probing are configured.
+func (c *Config) HubConfigs() []HubConfig {
+	switch {
+	case len(c.Hubs) > 0:
+		return c.Hubs
+	case c.probeOnly():
+		return nil
+	default:
+		return []HubConfig{c.Hub}
+	}
+}
+
+// probeOnly checks if only modules for probing and neither the single hub nor a list of hubs are configured
+func (c *Config) probeOnly() bool {
+	return len(c.Modules) > 0 && len(c.Hubs) == 0 && c.Hub == defaultHubConfig()
+}
+
+// HubConfig returns the configuration for connecting to the hub at the given address with the settings of the module
+func (m ModuleConfig) HubConfig(address string, port int) HubConfig {
+	return HubConfig{
+		Address:            address,
+		Port:               port,
+		Token:              m.Token,
+		TokenFile:          m.TokenFile,
+		TLSFingerprint:     m.TLSFingerprint,
+		TLSFingerprintFile: m.TLSFingerprintFile,
+	}
+}
+
+// Label returns the name identifying the hub, which is the configured name or a name derived from address
+// or discovery criteria
+func (h HubConfig) Label() string {
+	switch {
+	case h.Name != "":
+		return h.Name
+	case h.Address != "":
+		return h.Address
+	case h.Discovery.Name != "":
+		return h.Discovery.Name
+	case h.Discovery.ID != "":
+		return h.Discovery.ID
+	default:
+		return "discovered"
+	}
+}
+
+func (c *Config) validateHubs() []error {
+	var errs []error
+	if c.Hub != defaultHubConfig() {
+		errs = append(errs, errors.New("hub: must not be set together with hubs (check the IKEA_* environment variables, they only apply to hub)"))
+	}
+	labels := make(map[string]bool)
+	stateFiles := make(map[string]bool)
+	for i, hub := range c.Hubs {
+		prefix := fmt.Sprintf("hubs[%d]", i)
+		errs = append(errs, hub.validate(prefix)...)
+		errs = append(errs, hub.validateServed(prefix)...)
+		if labels[hub.Label()] {
+			errs = append(errs, fmt.Errorf("%s.name: %q is used by more than one hub", prefix, hub.Label()))
+		}
+		labels[hub.Label()] = true
+		if hub.StateFile != "" {
+			if stateFiles[hub.StateFile] {
+				errs = append(errs, fmt.Errorf("%s.state_file: %q is used by more than one hub", prefix, hub.StateFile))
+			}
+			stateFiles[hub.StateFile] = true
+		}
+		if len(c.Hubs) > 1 && hub.Address == "" && hub.Discovery == (DiscoveryConfig{}) {
+			errs = append(errs, fmt.Errorf("%s.discovery: id or name must be set to discover one of several hubs", prefix))
+		}
+	}
+	return errs
+}
+
+func (h *HubConfig) validate(prefix string) []error {
+	var errs []error
+	if h.Address != "" && (strings.Contains(h.Address, "://") || strings.Contains(h.Address, "/")) {
+		errs = append(errs, fmt.Errorf("%s.address: expected host name or IP address without scheme or path, got %q", prefix, h.Address))
+	}
+	errs = append(errs, validatePort(prefix+".port", h.Port))
+	if h.Token != "" && h.TokenFile != "" {
+		errs = append(errs, fmt.Errorf("%s.token: must not be set together with %s.token_file", prefix, prefix))
+	} else if h.TokenFile != "" {
+		if _, err := ReadSecretFile(h.TokenFile); err != nil {
+			errs = append(errs, fmt.Errorf("%s.token_file: %w", prefix, err))
+		}
+	} else if h.Token == "" {
+		errs = append(errs, fmt.Errorf("%s.token: must be set (or use IKEA_TOKEN, IKEA_TOKEN_FILE or %s.token_file)", prefix, prefix))
+	}
+	if h.TrustOnFirstUse && h.StateFile == "" {
+		errs = append(errs, fmt.Errorf("%s.state_file: must be set when %s.trust_on_first_use is enabled (or use IKEA_STATE_FILE)", prefix, prefix))
+	}
+	switch {
+	case h.TLSFingerprint != "" && h.TLSFingerprintFile != "":
+		errs = append(errs, fmt.Errorf("%s.tls_fingerprint: must not be set together with %s.tls_fingerprint_file", prefix, prefix))
+	case h.TrustOnFirstUse:
+		if h.TLSFingerprint != "" || h.TLSFingerprintFile != "" {
+			errs = append(errs, fmt.Errorf("%s.tls_fingerprint: must not be set when %s.trust_on_first_use is enabled", prefix, prefix))
+		}
+	case h.TLSFingerprintFile != "":
+		fingerprint, err := ReadSecretFile(h.TLSFingerprintFile)
+		if err == nil {
+			err = ValidateFingerprint(fingerprint)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.tls_fingerprint_file: %w", prefix, err))
+		}
+	case h.TLSFingerprint == "":
+		errs = append(errs, fmt.Errorf("%s.tls_fingerprint: must be set unless %s.trust_on_first_use is enabled (or use IKEA_TLS_FINGERPRINT, IKEA_TLS_FINGERPRINT_FILE or %s.tls_fingerprint_file)", prefix, prefix, prefix))
+	default:
+		if err := ValidateFingerprint(h.TLSFingerprint); err != nil {
+			errs = append(errs, fmt.Errorf("%s.tls_fingerprint: %w", prefix, err))
+		}
+	}
+	return errs
+}
+
+// validateServed checks the settings only used for hubs served by the exporter, not for probed hubs
+func (h *HubConfig) validateServed(prefix string) []error {
+	var errs []error
+	if h.ResyncInterval < minResyncInterval {
+		errs = append(errs, fmt.Errorf("%s.resync_interval: expected at least %s, got %s", prefix, minResyncInterval, h.ResyncInterval))
+	}
+	if h.EventSilenceThreshold < 0 {
+		errs = append(errs, fmt.Errorf("%s.event_silence_threshold: must not be negative, got %s", prefix, h.EventSilenceThreshold))
+	}
+	if h.KeepAliveInterval != 0 && h.KeepAliveInterval < minKeepAliveInterval {
+		errs = append(errs, fmt.Errorf("%s.keep_alive_interval: expected 0 or at least %s, got %s", prefix, minKeepAliveInterval, h.KeepAliveInterval))
+	}
+	return errs
+}
+
+// ValidateFingerprint checks if the given value is a SHA-256 fingerprint, either as plain hex string or
+// in the format printed by openssl (e.g. 'sha256 Fingerprint=AB:CD:...')
+func ValidateFingerprint(fingerprint string) error {
+	normalized := NormalizeFingerprint(fingerprint)
+	if len(normalized) != 64 {
+		return fmt.Errorf("expected SHA-256 fingerprint with 64 hex digits, got %d digits", len(normalized))
+	}
+	for _, c := range normalized {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return fmt.Errorf("invalid character %q in fingerprint", c)
+		}
+	}
+	return nil
+}
+
+// NormalizeFingerprint removes the openssl prefix and the colons and converts the fingerprint to lower case.
+func NormalizeFingerprint(fingerprint string) string {
+	parts := strings.SplitN(fingerprint, "=", 2)
+	normalized := strings.TrimSpace(parts[len(parts)-1])
+	normalized = strings.ReplaceAll(normalized, ":", "")
+	return strings.ToLower(normalized)
+}
+
+func validatePort(name string, port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("%s: expected port between 1 and 65535, got %d", name, port)
+	}
+	return nil
+}
+
+func lookupString(name string, target *string) {
+	if value, present := os.LookupEnv(name); present {
+		*target = value
+	}
+}
+
+func lookupBool(name string, target *bool) error {
+	value, present := os.LookupEnv(name)
+	if !present {
+		return nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%s: expected boolean, got %q", name, value)
+	}
+	*target = parsed
+	return nil
+}
+
+func lookupInt(name string, target *int) error {
+	value, present := os.LookupEnv(name)
+	if !present {
+		return nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%s: expected integer, got %q", name, value)
+	}
+	*target = parsed
+	return nil
+}
+
+func lookupDuration(name string, target *time.Duration) error {
+	value, present := os.LookupEnv(name)
+	if !present {
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%s: expected duration (e.g. 5m), got %q", name, value)
+	}
+	*target = parsed
+	return nil
+}
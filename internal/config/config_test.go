@@ -0,0 +1,444 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	fingerprint        = strings.Repeat("ab", 32)
+	opensslFingerprint = "sha256 Fingerprint=" + strings.Repeat("AB:", 31) + "AB"
+)
+
+var environmentVariables = []string{
+	"IKEA_ADDRESS", "IKEA_TOKEN", "IKEA_TLS_FINGERPRINT", "IKEA_TOKEN_FILE", "IKEA_TLS_FINGERPRINT_FILE",
+	"IKEA_STATE_FILE", "IKEA_HUB_ID", "IKEA_HUB_NAME", "IKEA_TIMEZONE", "IKEA_PORT", "IKEA_TRUST_ON_FIRST_USE",
+	"IKEA_RESYNC_INTERVAL", "IKEA_EVENT_SILENCE_THRESHOLD", "IKEA_KEEP_ALIVE_INTERVAL", "IKEA_SERVER_PORT",
+}
+
+// setEnvironment replaces the environment variables of the exporter by the given ones for the test
+func setEnvironment(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, name := range environmentVariables {
+		t.Setenv(name, "") // restored after the test
+		_ = os.Unsetenv(name)
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+}
+
+// writeFile writes the content to a file in the temporary directory of the test and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	file := `
+hub:
+  address: 192.168.1.10
+  port: 8444
+  token: file-token
+  tls_fingerprint: ` + fingerprint + `
+  resync_interval: 10m
+server:
+  port: 9200
+`
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    HubConfig
+		wantErr string
+	}{
+		{
+			name: "file only",
+			want: HubConfig{Address: "192.168.1.10", Port: 8444, Token: "file-token", TLSFingerprint: fingerprint,
+				ResyncInterval: 10 * time.Minute},
+		},
+		{
+			name: "environment overrides file",
+			env: map[string]string{"IKEA_ADDRESS": "192.168.1.20", "IKEA_PORT": "8445", "IKEA_TOKEN": "env-token",
+				"IKEA_RESYNC_INTERVAL": "15m", "IKEA_TRUST_ON_FIRST_USE": "true", "IKEA_STATE_FILE": "/state.json"},
+			want: HubConfig{Address: "192.168.1.20", Port: 8445, Token: "env-token", TLSFingerprint: fingerprint,
+				ResyncInterval: 15 * time.Minute, TrustOnFirstUse: true, StateFile: "/state.json"},
+		},
+		{
+			name: "environment selects discovered hub",
+			env:  map[string]string{"IKEA_HUB_ID": "hub-id", "IKEA_HUB_NAME": "gw2-abc"},
+			want: HubConfig{Address: "192.168.1.10", Port: 8444, Token: "file-token", TLSFingerprint: fingerprint,
+				ResyncInterval: 10 * time.Minute, Discovery: DiscoveryConfig{ID: "hub-id", Name: "gw2-abc"}},
+		},
+		{
+			name:    "invalid environment values",
+			env:     map[string]string{"IKEA_PORT": "https", "IKEA_KEEP_ALIVE_INTERVAL": "often"},
+			wantErr: "IKEA_PORT: expected integer",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setEnvironment(t, test.env)
+			cfg, err := Load(writeFile(t, "config.yaml", file))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Load() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() failed: %v", err)
+			}
+			if cfg.Hub != test.want {
+				t.Errorf("Load() hub = %+v, want %+v", cfg.Hub, test.want)
+			}
+			if cfg.Server.Port != 9200 {
+				t.Errorf("Load() server port = %d, want %d", cfg.Server.Port, 9200)
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setEnvironment(t, nil)
+	cfg, err := Load(writeFile(t, "config.yaml", `
+hubs:
+  - address: 192.168.1.10
+modules:
+  default: {}
+`))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if hub := cfg.Hubs[0]; hub.Port != DefaultHubPort || hub.ResyncInterval != DefaultResyncInterval {
+		t.Errorf("hub port = %d, resync interval = %s, want %d, %s", hub.Port, hub.ResyncInterval, DefaultHubPort,
+			DefaultResyncInterval)
+	}
+	if module := cfg.Modules["default"]; module.Port != DefaultHubPort || module.Timeout != DefaultProbeTimeout {
+		t.Errorf("module port = %d, timeout = %s, want %d, %s", module.Port, module.Timeout, DefaultHubPort,
+			DefaultProbeTimeout)
+	}
+	if cfg.Server.Port != DefaultServerPort {
+		t.Errorf("server port = %d, want %d", cfg.Server.Port, DefaultServerPort)
+	}
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	setEnvironment(t, nil)
+	if _, err := Load(writeFile(t, "config.yaml", "hub:\n  adress: 192.168.1.10\n")); err == nil {
+		t.Fatal("Load() succeeded for a misspelled field")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// The placeholders in the configuration files are replaced by the fingerprint and the paths of the secret files
+	placeholders := strings.NewReplacer(
+		"$FINGERPRINT_FILE", writeFile(t, "fingerprint", opensslFingerprint+"\n"),
+		"$FINGERPRINT", fingerprint,
+		"$TOKEN_FILE", writeFile(t, "token", "file-token\n"),
+	)
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		wantErrs []string // empty if valid
+	}{
+		{
+			name: "single hub",
+			file: `
+hub:
+  address: 192.168.1.10
+  token: token
+  tls_fingerprint: $FINGERPRINT`,
+		},
+		{
+			name: "single hub from environment",
+			env:  map[string]string{"IKEA_ADDRESS": "192.168.1.10", "IKEA_TOKEN": "token", "IKEA_TLS_FINGERPRINT": fingerprint},
+		},
+		{
+			name: "secret files",
+			file: `
+hub:
+  token_file: $TOKEN_FILE
+  tls_fingerprint_file: $FINGERPRINT_FILE`,
+		},
+		{
+			name: "hub together with hubs",
+			file: `
+hub:
+  address: 192.168.1.10
+hubs:
+  - address: 192.168.1.11
+    token: token
+    tls_fingerprint: $FINGERPRINT`,
+			wantErrs: []string{"hub: must not be set together with hubs"},
+		},
+		{
+			name: "environment together with hubs",
+			file: `
+hubs:
+  - address: 192.168.1.11
+    token: token
+    tls_fingerprint: $FINGERPRINT`,
+			env:      map[string]string{"IKEA_TOKEN": "token"},
+			wantErrs: []string{"hub: must not be set together with hubs"},
+		},
+		{
+			name: "token together with token file",
+			file: `
+hub:
+  token: token
+  token_file: $TOKEN_FILE
+  tls_fingerprint: $FINGERPRINT`,
+			wantErrs: []string{"hub.token: must not be set together with hub.token_file"},
+		},
+		{
+			name: "missing token",
+			file: `
+hub:
+  tls_fingerprint: $FINGERPRINT`,
+			wantErrs: []string{"hub.token: must be set"},
+		},
+		{
+			name: "missing token file",
+			file: `
+hub:
+  token_file: /nonexistent/token
+  tls_fingerprint: $FINGERPRINT`,
+			wantErrs: []string{"hub.token_file:"},
+		},
+		{
+			name: "fingerprint in openssl format",
+			file: `
+hub:
+  token: token
+  tls_fingerprint: "` + opensslFingerprint + `"`,
+		},
+		{
+			name: "fingerprint too short",
+			file: `
+hub:
+  token: token
+  tls_fingerprint: abcd`,
+			wantErrs: []string{"hub.tls_fingerprint: expected SHA-256 fingerprint with 64 hex digits, got 4 digits"},
+		},
+		{
+			name: "fingerprint with invalid character",
+			file: `
+hub:
+  token: token
+  tls_fingerprint: ` + strings.Repeat("xy", 32),
+			wantErrs: []string{"hub.tls_fingerprint: invalid character 'x' in fingerprint"},
+		},
+		{
+			name: "missing fingerprint",
+			file: `
+hub:
+  token: token`,
+			wantErrs: []string{"hub.tls_fingerprint: must be set unless hub.trust_on_first_use is enabled"},
+		},
+		{
+			name: "fingerprint together with fingerprint file",
+			file: `
+hub:
+  token: token
+  tls_fingerprint: $FINGERPRINT
+  tls_fingerprint_file: $FINGERPRINT_FILE`,
+			wantErrs: []string{"hub.tls_fingerprint: must not be set together with hub.tls_fingerprint_file"},
+		},
+		{
+			name: "trust on first use",
+			file: `
+hub:
+  token: token
+  trust_on_first_use: true
+  state_file: /state.json`,
+		},
+		{
+			name: "trust on first use without state file",
+			file: `
+hub:
+  token: token
+  trust_on_first_use: true`,
+			wantErrs: []string{"hub.state_file: must be set when hub.trust_on_first_use is enabled"},
+		},
+		{
+			name: "trust on first use with fingerprint",
+			file: `
+hub:
+  token: token
+  trust_on_first_use: true
+  state_file: /state.json
+  tls_fingerprint: $FINGERPRINT`,
+			wantErrs: []string{"hub.tls_fingerprint: must not be set when hub.trust_on_first_use is enabled"},
+		},
+		{
+			name: "several hubs",
+			file: `
+hubs:
+  - name: home
+    address: 192.168.1.10
+    token: token
+    tls_fingerprint: $FINGERPRINT
+    state_file: /home.json
+  - name: garage
+    discovery:
+      name: gw2-abc
+    token: token
+    tls_fingerprint: $FINGERPRINT
+    state_file: /garage.json`,
+		},
+		{
+			name: "duplicate hub names and state files",
+			file: `
+hubs:
+  - name: home
+    address: 192.168.1.10
+    token: token
+    tls_fingerprint: $FINGERPRINT
+    state_file: /state.json
+  - name: home
+    address: 192.168.1.11
+    token: token
+    tls_fingerprint: $FINGERPRINT
+    state_file: /state.json`,
+			wantErrs: []string{`hubs[1].name: "home" is used by more than one hub`,
+				`hubs[1].state_file: "/state.json" is used by more than one hub`},
+		},
+		{
+			name: "duplicate hub labels from address",
+			file: `
+hubs:
+  - address: 192.168.1.10
+    token: token
+    tls_fingerprint: $FINGERPRINT
+  - address: 192.168.1.10
+    token: token
+    tls_fingerprint: $FINGERPRINT`,
+			wantErrs: []string{`hubs[1].name: "192.168.1.10" is used by more than one hub`},
+		},
+		{
+			name: "several discovered hubs without criteria",
+			file: `
+hubs:
+  - name: home
+    token: token
+    tls_fingerprint: $FINGERPRINT
+  - name: garage
+    token: token
+    tls_fingerprint: $FINGERPRINT`,
+			wantErrs: []string{"hubs[0].discovery: id or name must be set", "hubs[1].discovery: id or name must be set"},
+		},
+		{
+			name: "probe only",
+			file: `
+modules:
+  default:
+    token: token
+    tls_fingerprint: $FINGERPRINT`,
+		},
+		{
+			name: "probe only with invalid module",
+			file: `
+modules:
+  default:
+    tls_fingerprint: abcd
+    timeout: -1s`,
+			wantErrs: []string{"modules.default.token: must be set", "modules.default.tls_fingerprint: expected SHA-256",
+				"modules.default.timeout: must be positive"},
+		},
+		{
+			name: "probe only with hub from environment",
+			file: `
+modules:
+  default:
+    token: token
+    tls_fingerprint: $FINGERPRINT`,
+			env:      map[string]string{"IKEA_ADDRESS": "192.168.1.10"},
+			wantErrs: []string{"hub.token: must be set"},
+		},
+		{
+			name:     "nothing configured",
+			wantErrs: []string{"hub.token: must be set", "hub.tls_fingerprint: must be set"},
+		},
+		{
+			name: "served hub settings",
+			file: `
+hub:
+  token: token
+  tls_fingerprint: $FINGERPRINT
+  resync_interval: 5s
+  event_silence_threshold: -1s
+  keep_alive_interval: 1s`,
+			wantErrs: []string{"hub.resync_interval: expected at least 10s", "hub.event_silence_threshold: must not be negative",
+				"hub.keep_alive_interval: expected 0 or at least 10s"},
+		},
+		{
+			name: "invalid address, ports and timezone",
+			file: `
+hub:
+  address: https://192.168.1.10/
+  port: 70000
+  token: token
+  tls_fingerprint: $FINGERPRINT
+server:
+  port: 0
+timezone: Mars/Olympus`,
+			wantErrs: []string{"hub.address: expected host name or IP address", "hub.port: expected port between 1 and 65535",
+				"server.port: expected port between 1 and 65535", `timezone: unknown IANA timezone "Mars/Olympus"`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setEnvironment(t, test.env)
+			path := ""
+			if test.file != "" {
+				path = writeFile(t, "config.yaml", placeholders.Replace(test.file))
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load() failed: %v", err)
+			}
+			err = cfg.Validate()
+			if len(test.wantErrs) == 0 {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want %q", test.wantErrs)
+			}
+			for _, wantErr := range test.wantErrs {
+				if !strings.Contains(err.Error(), wantErr) {
+					t.Errorf("Validate() = %v, want %q", err, wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestHubConfigs(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want int
+	}{
+		{name: "single hub", cfg: Config{Hub: defaultHubConfig()}, want: 1},
+		{name: "several hubs", cfg: Config{Hub: defaultHubConfig(), Hubs: []HubConfig{{Name: "a"}, {Name: "b"}}}, want: 2},
+		{name: "probe only", cfg: Config{Hub: defaultHubConfig(), Modules: map[string]ModuleConfig{"default": {}}}, want: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := len(test.cfg.HubConfigs()); got != test.want {
+				t.Errorf("HubConfigs() returned %d hubs, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadSecretFile reads a secret from a file, e.g. a Docker or Kubernetes secret mount.
+// Leading and trailing whitespace is removed.
+func ReadSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret file: %w", err)
+	}
+	secret := strings.TrimSpace(string(content))
+	if secret == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	return secret, nil
+}
+
+// ResolveSecrets returns a copy of the hub configuration with the token and the TLS fingerprint read from the
+// secret files if configured.
+func (h HubConfig) ResolveSecrets() (HubConfig, error) {
+	if h.TokenFile != "" {
+		token, err := ReadSecretFile(h.TokenFile)
+		if err != nil {
+			return h, err
+		}
+		h.Token = token
+	}
+	if h.TLSFingerprintFile != "" {
+		fingerprint, err := ReadSecretFile(h.TLSFingerprintFile)
+		if err != nil {
+			return h, err
+		}
+		h.TLSFingerprint = fingerprint
+	}
+	return h, nil
+}
@@ -0,0 +1,143 @@
+package dirigera
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+)
+
+type airPurifierMetric struct {
+	fanModeMetric           *prometheus.GaugeVec
+	motorRuntimeMetric      *prometheus.GaugeVec
+	fanSpeedMetric          *prometheus.GaugeVec
+	pm25Metric              *prometheus.GaugeVec
+	filterElapsedTimeMetric *prometheus.GaugeVec
+	filterLifetimeMetric    *prometheus.GaugeVec
+	filterAlarmMetric       *prometheus.GaugeVec
+	childLockMetric         *prometheus.GaugeVec
+	statusLightMetric       *prometheus.GaugeVec
+}
+
+func newAirPurifierMetric(registerer prometheus.Registerer) dirigeraMetric {
+	metric := &airPurifierMetric{
+		fanModeMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "air_purifier",
+			Name:      "fan_mode",
+			Help:      "Current fan mode of an air purifier (auto, low, medium, high or off), the value is always 1",
+		}, append(metricLabelNames, "mode")),
+		motorRuntimeMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "air_purifier",
+			Name:      "motor_runtime_seconds",
+			Help:      "Total runtime of the motor of an air purifier (seconds)",
+		}, metricLabelNames),
+		fanSpeedMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "air_purifier",
+			Name:      "current_fan_speed",
+			Help:      "Current speed of the fan of an air purifier (0 = off, 1 to 50)",
+		}, metricLabelNames),
+		pm25Metric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "air_purifier",
+			Name:      "current_pm25",
+			Help:      "Current PM2.5 concentration measured by an air purifier (micrograms per cubic meter)",
+		}, metricLabelNames),
+		filterElapsedTimeMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "air_purifier",
+			Name:      "filter_elapsed_time_seconds",
+			Help:      "Time the filter of an air purifier is in use (seconds)",
+		}, metricLabelNames),
+		filterLifetimeMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "air_purifier",
+			Name:      "filter_lifetime_seconds",
+			Help:      "Lifetime of the filter of an air purifier (seconds)",
+		}, metricLabelNames),
+		filterAlarmMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "air_purifier",
+			Name:      "filter_alarm",
+			Help:      "Filter of an air purifier needs to be replaced (0 = ok, 1 = replace)",
+		}, metricLabelNames),
+		childLockMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "air_purifier",
+			Name:      "child_lock",
+			Help:      "Child lock of an air purifier (0 = unlocked, 1 = locked)",
+		}, metricLabelNames),
+		statusLightMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "air_purifier",
+			Name:      "status_light",
+			Help:      "Status light of an air purifier (0 = off, 1 = on)",
+		}, metricLabelNames),
+	}
+	registerer.MustRegister(metric.fanModeMetric)
+	registerer.MustRegister(metric.motorRuntimeMetric)
+	registerer.MustRegister(metric.fanSpeedMetric)
+	registerer.MustRegister(metric.pm25Metric)
+	registerer.MustRegister(metric.filterElapsedTimeMetric)
+	registerer.MustRegister(metric.filterLifetimeMetric)
+	registerer.MustRegister(metric.filterAlarmMetric)
+	registerer.MustRegister(metric.childLockMetric)
+	registerer.MustRegister(metric.statusLightMetric)
+
+	return metric
+}
+
+func (m *airPurifierMetric) update(device client.Device, labels prometheus.Labels) {
+	if fanMode, hasFanMode := device.Attributes["fanMode"].(string); hasFanMode {
+		m.fanModeMetric.DeletePartialMatch(prometheus.Labels{"hub_id": labels["hub_id"], "device_id": labels["device_id"]})
+		m.fanModeMetric.With(withLabel(labels, "mode", fanMode)).Set(1)
+	}
+	if runtime, hasRuntime := device.Attributes["motorRuntime"].(float64); hasRuntime {
+		m.motorRuntimeMetric.With(labels).Set(minutesToSeconds(runtime))
+	}
+	if speed, hasSpeed := device.Attributes["motorState"].(float64); hasSpeed {
+		m.fanSpeedMetric.With(labels).Set(speed)
+	}
+	if pm25, hasPM25 := device.Attributes["currentPM25"].(float64); hasPM25 {
+		m.pm25Metric.With(labels).Set(pm25)
+	}
+	if elapsed, hasElapsed := device.Attributes["filterElapsedTime"].(float64); hasElapsed {
+		m.filterElapsedTimeMetric.With(labels).Set(minutesToSeconds(elapsed))
+	}
+	if lifetime, hasLifetime := device.Attributes["filterLifetime"].(float64); hasLifetime {
+		m.filterLifetimeMetric.With(labels).Set(minutesToSeconds(lifetime))
+	}
+	setFlag(m.filterAlarmMetric, device, "filterAlarmStatus", labels)
+	setFlag(m.childLockMetric, device, "childLock", labels)
+	setFlag(m.statusLightMetric, device, "statusLight", labels)
+}
+
+func (m *airPurifierMetric) remove(labels prometheus.Labels) {
+	m.fanModeMetric.DeletePartialMatch(labels)
+	m.motorRuntimeMetric.DeletePartialMatch(labels)
+	m.fanSpeedMetric.DeletePartialMatch(labels)
+	m.pm25Metric.DeletePartialMatch(labels)
+	m.filterElapsedTimeMetric.DeletePartialMatch(labels)
+	m.filterLifetimeMetric.DeletePartialMatch(labels)
+	m.filterAlarmMetric.DeletePartialMatch(labels)
+	m.childLockMetric.DeletePartialMatch(labels)
+	m.statusLightMetric.DeletePartialMatch(labels)
+}
+
+// setFlag sets the metric to 1 if the boolean attribute is true, 0 if false, and leaves it unchanged if missing
+func setFlag(metric *prometheus.GaugeVec, device client.Device, attribute string, labels prometheus.Labels) {
+	flag, hasFlag := device.Attributes[attribute].(bool)
+	if !hasFlag {
+		return
+	}
+	var value float64 = 0
+	if flag {
+		value = 1
+	}
+	metric.With(labels).Set(value)
+}
+
+// minutesToSeconds converts the times reported by the air purifier in minutes to the base unit
+func minutesToSeconds(minutes float64) float64 {
+	return minutes * 60
+}
@@ -11,7 +11,7 @@ type baseDeviceMetric struct {
 	batteryLevelMetric *prometheus.GaugeVec
 }
 
-func newBaseDeviceMetric() dirigeraMetric {
+func newBaseDeviceMetric(registerer prometheus.Registerer) dirigeraMetric {
 	metric := &baseDeviceMetric{
 		reachableMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: "ikea",
@@ -32,9 +32,9 @@ func newBaseDeviceMetric() dirigeraMetric {
 			Help:      "Current battery level of a device (percent)",
 		}, metricLabelNames),
 	}
-	prometheus.MustRegister(metric.reachableMetric)
-	prometheus.MustRegister(metric.lastSeenMetric)
-	prometheus.MustRegister(metric.batteryLevelMetric)
+	registerer.MustRegister(metric.reachableMetric)
+	registerer.MustRegister(metric.lastSeenMetric)
+	registerer.MustRegister(metric.batteryLevelMetric)
 
 	return metric
 }
@@ -50,3 +50,9 @@ func (m *baseDeviceMetric) update(device client.Device, labels prometheus.Labels
 		m.batteryLevelMetric.With(labels).Set(batteryLevel)
 	}
 }
+
+func (m *baseDeviceMetric) remove(labels prometheus.Labels) {
+	m.reachableMetric.DeletePartialMatch(labels)
+	m.lastSeenMetric.DeletePartialMatch(labels)
+	m.batteryLevelMetric.DeletePartialMatch(labels)
+}
@@ -0,0 +1,177 @@
+package dirigera
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
+)
+
+// watchInterval is the interval for checking the secret files
+const watchInterval = 10 * time.Second
+
+// resolve returns the configuration with the secrets read from files, the address of the discovered hub and
+// the fingerprint to be used for the connection.
+func resolve(cfg config.HubConfig, persist bool) (config.HubConfig, error) {
+	cfg, err := cfg.ResolveSecrets()
+	if err != nil {
+		return cfg, err
+	}
+	cfg, err = resolveAddress(cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("error discovering hub: %w", err)
+	}
+	fingerprint, err := resolveFingerprint(cfg, persist)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.TLSFingerprint = fingerprint
+	return cfg, nil
+}
+
+// connect creates a client for the hub, which measures the requests, and registers the event handlers
+func (d *dirigeraClient) connect(cfg config.HubConfig) client.Client {
+	hub := &instrumentedHub{Client: connect(cfg), label: d.label, metrics: d.metrics.exporter, stopped: d.stopped}
+	hub.RegisterEventHandler(d.recordEvent) // all events
+	hub.RegisterEventHandler(d.updateMetricFromEvent, "deviceStateChanged", "deviceConfigurationChanged")
+	hub.RegisterEventHandler(d.removeDeviceFromEvent, "deviceRemoved")
+	hub.SetEventLog(&eventLog{connected: d.loopConnected, failed: d.loopFailed})
+	return hub
+}
+
+func (d *dirigeraClient) currentHub() client.Client {
+	d.hubMutex.Lock()
+	defer d.hubMutex.Unlock()
+
+	return d.hub
+}
+
+// Reconfigure applies a changed hub configuration. The hub is reconnected if the connection settings changed.
+// With a changed state file, the state is saved to the old file and read again from the new one.
+func (d *dirigeraClient) Reconfigure(cfg config.HubConfig) {
+	d.updateMutex.Lock()
+	if stateFile := d.stateFile(); cfg.StateFile != stateFile {
+		fmt.Printf("State file of hub %s changed from %q to %q\n", d.label, stateFile, cfg.StateFile)
+		d.saveState(true)
+		d.firmware, d.energy = nil, nil // read by loadState with the next update
+	}
+	d.hubMutex.Lock()
+	d.cfg = cfg
+	d.hubMutex.Unlock()
+	d.updateMutex.Unlock()
+
+	select {
+	case d.reconfigured <- struct{}{}:
+	default: // check already pending
+	}
+}
+
+// reconnect creates a new client for the hub - a discovered hub is discovered again - and synchronizes all devices
+// because events might have been missed while disconnected
+func (d *dirigeraClient) reconnect() error {
+	d.hubMutex.Lock()
+	configured := d.cfg
+	d.hubMutex.Unlock()
+
+	cfg, err := resolve(configured, true)
+	d.reportFingerprint(err)
+	if err != nil {
+		return err
+	}
+	if configured.Address == "" {
+		fmt.Printf("Discovered hub %s at %s:%d\n", d.label, cfg.Address, cfg.Port)
+	}
+	d.setHub(d.connect(cfg), cfg)
+	d.resetPowerSamples()
+	if err := d.load(); err != nil {
+		return err
+	}
+
+	d.hubMutex.Lock()
+	if !d.synchronized {
+		fmt.Printf("Initial sync of hub %s finished\n", d.label)
+		d.synchronized = true
+	}
+	d.hubMutex.Unlock()
+
+	d.updateHealth()
+	return nil
+}
+
+// reportFingerprint updates the fingerprint mismatch metric with the result of resolving the connection settings,
+// other errors leave it unchanged because the fingerprint was not checked
+func (d *dirigeraClient) reportFingerprint(err error) {
+	var mismatchError *FingerprintMismatchError
+	switch {
+	case errors.As(err, &mismatchError):
+		d.metrics.exporter.setFingerprintMismatch(d.label, true)
+	case err == nil:
+		d.metrics.exporter.setFingerprintMismatch(d.label, false)
+	}
+}
+
+func (d *dirigeraClient) setConnectionError(err error) {
+	d.hubMutex.Lock()
+	d.lastConnectionError = err
+	d.hubMutex.Unlock()
+
+	d.updateHealth()
+}
+
+func (d *dirigeraClient) setHub(hub client.Client, cfg config.HubConfig) {
+	d.hubMutex.Lock()
+	defer d.hubMutex.Unlock()
+
+	d.hub = hub
+	d.hubCfg = cfg
+}
+
+// checkConnection returns a new client if the connection settings changed, otherwise nil
+func (d *dirigeraClient) checkConnection() (client.Client, config.HubConfig, error) {
+	d.hubMutex.Lock()
+	configured, current := d.cfg, d.hubCfg
+	d.hubMutex.Unlock()
+
+	cfg, err := configured.ResolveSecrets()
+	if err != nil {
+		return nil, cfg, err
+	}
+	discoveryChanged := configured.Address == "" && cfg.Discovery != current.Discovery
+	if configured.Address == "" && !discoveryChanged {
+		// A discovered hub is discovered again when reconnecting after a failure or when the discovery criteria
+		// changed, otherwise the current address is kept
+		cfg.Address, cfg.Port = current.Address, current.Port
+	}
+
+	var reason string
+	switch {
+	case discoveryChanged:
+		if cfg, err = resolveAddress(cfg); err != nil {
+			return nil, cfg, fmt.Errorf("error discovering hub: %w", err)
+		}
+		reason = fmt.Sprintf("discovery criteria changed, hub found at %s:%d", cfg.Address, cfg.Port)
+	case cfg.Address != current.Address || cfg.Port != current.Port:
+		reason = fmt.Sprintf("hub moved from %s:%d to %s:%d", current.Address, current.Port, cfg.Address, cfg.Port)
+	case cfg.Token != current.Token:
+		reason = "access token changed"
+	case cfg.TrustOnFirstUse != current.TrustOnFirstUse:
+		reason = "trust on first use changed"
+	case cfg.TrustOnFirstUse && cfg.StateFile != current.StateFile:
+		reason = "state file with pinned TLS fingerprint changed"
+	case !cfg.TrustOnFirstUse && config.NormalizeFingerprint(cfg.TLSFingerprint) != config.NormalizeFingerprint(current.TLSFingerprint):
+		reason = "TLS fingerprint changed"
+	default:
+		return nil, cfg, nil
+	}
+
+	fingerprint, err := resolveFingerprint(cfg, true)
+	d.reportFingerprint(err)
+	if err != nil {
+		return nil, cfg, err
+	}
+	cfg.TLSFingerprint = fingerprint
+	fmt.Printf("Reconnecting to hub: %s\n", reason)
+	return d.connect(cfg), cfg, nil
+}
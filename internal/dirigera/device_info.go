@@ -0,0 +1,46 @@
+package dirigera
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// deviceInfoMetric contains the hardware and firmware information of all devices as labels
+type deviceInfoMetric struct {
+	infoMetric *prometheus.GaugeVec
+}
+
+func newDeviceInfoMetric(registerer prometheus.Registerer) *deviceInfoMetric {
+	metric := &deviceInfoMetric{
+		infoMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "device",
+			Name:      "info",
+			Help:      "Information about a device, the value is always 1",
+		}, append(metricLabelNames, "model", "manufacturer", "firmware_version", "hardware_version", "serial_number", "product_code")),
+	}
+	registerer.MustRegister(metric.infoMetric)
+
+	return metric
+}
+
+// update replaces the series of the device, so that changed details, e.g. after a firmware update, do not leave
+// the series with the old details behind
+func (m *deviceInfoMetric) update(details DeviceDetails, labels prometheus.Labels) {
+	infoLabels := prometheus.Labels{
+		"model":            details.Model,
+		"manufacturer":     details.Manufacturer,
+		"firmware_version": details.FirmwareVersion,
+		"hardware_version": details.HardwareVersion,
+		"serial_number":    details.SerialNumber,
+		"product_code":     details.ProductCode,
+	}
+	for name, value := range labels {
+		infoLabels[name] = value
+	}
+	m.infoMetric.DeletePartialMatch(prometheus.Labels{"hub_id": labels["hub_id"], "device_id": labels["device_id"]})
+	m.infoMetric.With(infoLabels).Set(1)
+}
+
+func (m *deviceInfoMetric) remove(labels prometheus.Labels) {
+	m.infoMetric.DeletePartialMatch(labels)
+}
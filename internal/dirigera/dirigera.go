@@ -2,10 +2,11 @@ package dirigera
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/salex-org/ikea-dirigera-exporter/internal/util"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/salex-org/ikea-dirigera-client/pkg/client"
@@ -15,111 +16,255 @@ type DirigeraClient interface {
 	Start() error
 	Shutdown() error
 	Health() error
-	GetHubName() string
+	Reconfigure(cfg config.HubConfig)
+	// Registry returns the devices of the hub, e.g. for subscribing to changes
+	Registry() *Registry
 }
 
 type dirigeraClient struct {
-	hub               client.Client
-	hubName           string
-	hubID             string
-	baseMetrics       dirigeraMetric
-	additionalMetrics map[string]dirigeraMetric  // key: device type
-	cache             map[string]*dirigeraDevice // key: normalized ID
+	label               string // name of the hub configuration
+	cfg                 config.HubConfig
+	hub                 client.Client
+	hubCfg              config.HubConfig // resolved configuration of the current hub connection
+	hubMutex            sync.Mutex
+	hubName             string        // guarded by updateMutex
+	hubID               string        // guarded by updateMutex
+	hubStatus           client.Device // last known status of the hub, guarded by updateMutex
+	lastConnectionError error         // error of the last connection attempt or event loop, nil if connected
+	synchronized        bool          // initial sync finished
+	connectedAt         time.Time     // last time the event connection was established
+	lastEvent           time.Time     // time of the last event received from the hub
+	healthReason        Reason
+	healthSince         time.Time // time of the last change of healthReason
+	stopped             chan struct{}
+	reconfigured        chan struct{}
+	loopConnected       chan struct{}
+	loopFailed          chan struct{}
+	stopOnce            sync.Once
+	metrics             *Metrics
+	updateMutex         sync.Mutex // serializes the updates of registry and metrics by events and resync
+	registry            *Registry
+	firmware            map[string]firmwareState // known firmware versions, key: device ID without suffix
+	energy              map[string]energyState   // energy offsets of outlets, key: device ID without suffix
+	stateChanged        bool                     // firmware versions or energy offsets not yet saved
+	powerSamples        map[string]powerSample   // last power readings of outlets, key: device ID without suffix
+	estimated           map[string]float64       // estimated energy consumed at outlets (kWh), key: device ID without suffix
 }
 
-type dirigeraDevice struct {
-	deviceName string
-	deviceType string
-	roomName   string
-	roomID     string
+// InitialSyncError is reported by the health check until the hub was reached for the first time
+type InitialSyncError struct {
+	Cause error // error of the last connection attempt, nil if not yet attempted
+}
+
+func (e *InitialSyncError) Error() string {
+	if e.Cause == nil {
+		return "initial sync pending"
+	}
+	return fmt.Sprintf("initial sync pending: %v", e.Cause)
+}
+
+func (e *InitialSyncError) Unwrap() error {
+	return e.Cause
+}
+
+// Pending marks the error as temporary state during startup for the ready check of the web server
+func (e *InitialSyncError) Pending() bool {
+	return true
 }
 
 type dirigeraMetric interface {
 	update(device client.Device, labels prometheus.Labels)
+	// remove deletes all series matching the labels, which identify a device by hub_id and device_id
+	remove(labels prometheus.Labels)
 }
 
-func NewDirigeraClient() (DirigeraClient, error) {
-	// Create client
-	dirigeraAddress := util.ReadEnvVar("IKEA_ADDRESS")
-	dirigeraPort, err := strconv.Atoi(util.ReadEnvVarWithDefault("IKEA_PORT", "8443"))
-	if err != nil {
-		return nil, fmt.Errorf("error parsing IKEA_PORT value: %w", err)
-	}
-	newClient := &dirigeraClient{
-		hub: client.Connect(dirigeraAddress, dirigeraPort, &client.Authorization{
-			AccessToken:    util.ReadEnvVar("IKEA_TOKEN"),
-			TLSFingerprint: util.ReadEnvVar("IKEA_TLS_FINGERPRINT"),
-		}),
-		cache:       make(map[string]*dirigeraDevice),
-		baseMetrics: newBaseDeviceMetric(),
-		additionalMetrics: map[string]dirigeraMetric{
-			"openCloseSensor":   newOpenCloseSensorMetric(),
-			"environmentSensor": newEnvironmentSensorMetric(),
-			"outlet":            newOutletMetric(),
-			"lightController":   newLightControllerMetric(),
-			"light":             newLightMetric(),
-		},
-	}
-
-	// Load hub information
-	hubStatus, err := newClient.hub.GetHubStatus()
-	if err != nil {
-		return nil, fmt.Errorf("error loading hub status: %w", err)
-	}
-	hubName, hasHubName := hubStatus.Attributes["customName"]
-	if !hasHubName {
-		return nil, fmt.Errorf("hub %s has no customName", hubStatus.ID)
+// NewDirigeraClient creates a client for the hub, which loads the data into the given metrics - they can be shared by
+// the clients of several hubs. The hub is connected in the background by Start, so the hub needs not to be reachable.
+func NewDirigeraClient(cfg config.HubConfig, metrics *Metrics) DirigeraClient {
+	metrics.exporter.addHub(cfg.Label())
+	metrics.exporter.setHubUp(cfg.Label(), ReasonPending)
+	return &dirigeraClient{
+		label:         cfg.Label(),
+		cfg:           cfg,
+		stopped:       make(chan struct{}),
+		reconfigured:  make(chan struct{}, 1),
+		loopConnected: make(chan struct{}, 1),
+		loopFailed:    make(chan struct{}, 1),
+		powerSamples:  make(map[string]powerSample),
+		estimated:     make(map[string]float64),
+		healthReason:  ReasonPending,
+		healthSince:   time.Now(),
+		registry:      newRegistry(),
+		metrics:       metrics,
 	}
-	newClient.hubName = hubName.(string)
-	newClient.hubID, _ = normalizeID(hubStatus.ID)
+}
 
-	// Register event handler
-	newClient.hub.RegisterEventHandler(newClient.updateMetricFromEvent, "deviceStateChanged")
+// load reads the hub information and all devices from the hub and updates the metrics
+func (d *dirigeraClient) load() error {
+	if err := d.loadHub(); err != nil {
+		return err
+	}
+	_, err := d.synchronize()
+	return err
+}
 
-	// Load initial data
-	devices, err := newClient.hub.ListDevices()
+// loadHub reads the hub status and updates the hub metrics, also the hub name and ID are updated
+func (d *dirigeraClient) loadHub() error {
+	hubStatus, err := d.currentHub().GetHubStatus()
 	if err != nil {
-		return nil, fmt.Errorf("error loading devices: %w", err)
+		return fmt.Errorf("error loading hub status: %w", err)
 	}
-	for _, device := range devices {
-		newClient.updateMetric(*device, nil)
+	hubName, hasHubName := hubStatus.Attributes["customName"].(string)
+	if !hasHubName {
+		return fmt.Errorf("hub %s has no customName", hubStatus.ID)
+	}
+	hubID, _ := normalizeID(hubStatus.ID)
+	d.updateMutex.Lock()
+	if d.hubName != "" && (hubName != d.hubName || hubID != d.hubID) {
+		d.relabelHub(hubID, hubName)
 	}
+	d.hubName = hubName
+	d.hubID = hubID
+	d.updateMutex.Unlock()
+	d.updateHub(*hubStatus)
+	return nil
+}
 
-	return newClient, nil
+// relabelHub deletes the series of the hub and all devices with the old hub labels and emits the series of the
+// devices again with the new labels from the registry. The registry must be locked by updateMutex.
+func (d *dirigeraClient) relabelHub(hubID, hubName string) {
+	fmt.Printf("Hub %s renamed from %s (%s) to %s (%s)\n", d.label, d.hubName, d.hubID, hubName, hubID)
+	d.metrics.hub.remove(d.hubLabels())
+	d.metrics.removeHubDevices(d.hubID)
+	d.hubName = hubName
+	d.hubID = hubID
+	for _, registered := range d.registry.Snapshot() {
+		// All known states are emitted when relabeling, so any state of the device can be passed
+		for _, state := range registered.States {
+			d.emit(registered, state, nil, true)
+			break
+		}
+	}
 }
 
+// Start connects to the hub, retrying until the hub is reached, and listens for events until Shutdown is called
 func (d *dirigeraClient) Start() error {
-	return d.hub.ListenForEvents()
+	return d.listen()
 }
 
 func (d *dirigeraClient) Shutdown() error {
-	return d.hub.StopEventListening()
+	d.stopOnce.Do(func() { close(d.stopped) })
+	// The last energy readings are otherwise only saved by the resync
+	d.updateMutex.Lock()
+	d.saveState(true)
+	d.updateMutex.Unlock()
+	hub := d.currentHub()
+	if hub == nil {
+		return nil
+	}
+	return hub.StopEventListening()
 }
 
+// Health returns a HubError categorizing the cause, which is an InitialSyncError until the hub was reached for the
+// first time, afterwards the error of the connection or the event loop, or an EventSilenceError if the hub sent no
+// events for too long
 func (d *dirigeraClient) Health() error {
-	return d.hub.GetEventLoopState()
+	d.hubMutex.Lock()
+	hub, synchronized, err := d.hub, d.synchronized, d.lastConnectionError
+	silence := d.silence()
+	d.hubMutex.Unlock()
+
+	switch {
+	case !synchronized:
+		return d.recordHealth(&InitialSyncError{Cause: err})
+	case err != nil:
+		return d.recordHealth(err)
+	}
+	if err := hub.GetEventLoopState(); err != nil {
+		return d.recordHealth(err)
+	}
+	if silence != nil {
+		return d.recordHealth(silence)
+	}
+	return d.recordHealth(nil)
 }
 
-func (d *dirigeraClient) GetHubName() string {
-	return d.hubName
+func (d *dirigeraClient) Registry() *Registry {
+	return d.registry
 }
 
 func (d *dirigeraClient) updateMetric(device client.Device, event *client.Event) {
 	if device.DetailedType == "gateway" {
-		return // skipping gateway itself
+		d.updateHub(device)
+		return
 	}
 	deviceID, _ := normalizeID(device.ID)
 
-	cachedDevice, err := d.readFromCache(device, deviceID)
-	if err != nil {
-		fmt.Printf("Warning: Could not read from cache: %v\n", err)
-		return
+	var rootDevice *client.Device
+	d.updateMutex.Lock()
+	for {
+		registered, isRegistered := d.registry.Get(deviceID)
+		if (isRegistered && (event == nil || !isRelabeled(registered, device))) || rootDevice != nil {
+			break
+		}
+		// The details are read from the hub without holding updateMutex, so a slow hub blocks neither the
+		// other updates nor the shutdown. The registry is checked again afterwards, it might have changed meanwhile.
+		d.updateMutex.Unlock()
+		var err error
+		rootDevice, err = d.readRootDevice(device, deviceID)
+		if err != nil {
+			fmt.Printf("Warning: Could not register device: %v\n", err)
+			d.metrics.exporter.countUpdateFailure(d.label)
+			return
+		}
+		d.updateMutex.Lock()
+	}
+	defer d.updateMutex.Unlock()
+
+	registered, isRegistered := d.registry.Get(deviceID)
+	relabeled := isRegistered && event != nil && isRelabeled(registered, device)
+	if relabeled {
+		// Delete the series with the old labels and read name and room again
+		fmt.Printf("Device %s renamed or moved to another room\n", device.ID)
+		d.metrics.removeDevice(d.hubID, deviceID)
 	}
+	d.metrics.exporter.countCacheLookup(d.label, isRegistered && !relabeled)
+	if !isRegistered || relabeled {
+		if _, _, err := d.registry.register(*rootDevice); err != nil {
+			fmt.Printf("Warning: Could not register device: %v\n", err)
+			d.metrics.exporter.countUpdateFailure(d.label)
+			return
+		}
+		d.metrics.exporter.setCacheSize(d.label, d.registry.size())
+	}
+	registered, _ = d.registry.updateState(device, event != nil)
+	d.emit(registered, device, event, relabeled)
+	if event != nil {
+		d.saveState(false)
+	}
+}
+
+// emit updates the metrics of the registered device from the device state, with relabeled the known states of the
+// device are emitted again. The registry must be locked by updateMutex.
+func (d *dirigeraClient) emit(registered RegisteredDevice, device client.Device, event *client.Event, relabeled bool) {
+	labels := d.createLabels(registered)
+	d.metrics.info.update(registered.Details, labels)
+	d.updateOTA(registered, device, relabeled, labels)
+	d.updateEnergy(registered, device, relabeled, labels)
+	d.updateEstimatedEnergy(registered, device, time.Now(), relabeled, labels)
 
-	if metric, metricFound := d.additionalMetrics[cachedDevice.deviceType]; metricFound {
-		labels := d.createLabels(cachedDevice, deviceID)
-		d.baseMetrics.update(device, labels)
-		metric.update(device, labels)
+	if metric, metricFound := d.metrics.additional[registered.Type]; metricFound {
+		if !relabeled {
+			d.metrics.base.update(device, labels)
+			metric.update(device, labels)
+			return
+		}
+		// The event contains only the changed values, so the known values of all devices sharing the labels are
+		// emitted again with the new labels
+		for _, knownDevice := range registered.States {
+			d.metrics.base.update(knownDevice, labels)
+			metric.update(knownDevice, labels)
+		}
 		return
 	}
 	fmt.Printf("Warning: No metric registered for %s:%s\n", device.Type, device.DetailedType)
@@ -128,59 +273,47 @@ func (d *dirigeraClient) updateMetric(device client.Device, event *client.Event)
 	}
 }
 
-func (d *dirigeraClient) updateMetricFromEvent(event client.Event) {
-	d.updateMetric(event.Device, &event)
-}
-
-func (d *dirigeraClient) readFromCache(device client.Device, deviceID string) (*dirigeraDevice, error) {
-	cachedDevice, isCached := d.cache[deviceID]
-
-	if !isCached {
-		return d.addToCache(device)
+// isRelabeled checks if the name or the room of the device changed, which are used as labels.
+// The name is taken from the major device only, like when registering.
+func isRelabeled(registered RegisteredDevice, device client.Device) bool {
+	_, isRoot := normalizeID(device.ID)
+	if name, hasName := device.Attributes["customName"].(string); hasName && isRoot && name != registered.Name {
+		return true
 	}
+	return device.Room.ID != "" && (device.Room.ID != registered.RoomID || device.Room.Name != registered.RoomName)
+}
 
-	return cachedDevice, nil
+func (d *dirigeraClient) updateMetricFromEvent(event client.Event) {
+	d.updateMetric(event.Device, &event)
 }
 
-func (d *dirigeraClient) addToCache(device client.Device) (*dirigeraDevice, error) {
+// readRootDevice reads the major device of the device from the hub for registering it
+func (d *dirigeraClient) readRootDevice(device client.Device, deviceID string) (*client.Device, error) {
 	rootDeviceID := device.ID
-	deviceID, isRoot := normalizeID(device.ID)
-	if !isRoot { // read deviceDetails from attached major device to ensure correct names and rooms
+	if _, isRoot := normalizeID(device.ID); !isRoot { // read deviceDetails from attached major device to ensure correct names and rooms
 		rootDeviceID = fmt.Sprintf("%s_1", deviceID)
 	}
-	rootDevice, err := d.hub.GetDevice(rootDeviceID) // read deviceDetails from hub to ensure completeness
+	rootDevice, err := d.currentHub().GetDevice(rootDeviceID) // read deviceDetails from hub to ensure completeness
 	if err != nil {
 		return nil, fmt.Errorf("error getting device details for device %s: %w", rootDeviceID, err)
 	}
-	deviceName, hasDeviceName := rootDevice.Attributes["customName"].(string)
-	if !hasDeviceName {
-		return nil, fmt.Errorf("device %s has no customName", rootDeviceID)
-	}
 	if device.Room.Name == "" {
 		return nil, fmt.Errorf("device %s has no room name", rootDeviceID)
 	}
-	cachedDevice := &dirigeraDevice{
-		deviceName: deviceName,
-		deviceType: rootDevice.DetailedType,
-		roomName:   rootDevice.Room.Name,
-		roomID:     rootDevice.Room.ID,
-	}
-	d.cache[deviceID] = cachedDevice
-
-	return cachedDevice, nil
+	return rootDevice, nil
 }
 
 var metricLabelNames = []string{"hub_id", "hub_name", "room_id", "room_name", "device_id", "device_name", "device_type"}
 
-func (d *dirigeraClient) createLabels(device *dirigeraDevice, deviceID string) prometheus.Labels {
+func (d *dirigeraClient) createLabels(device RegisteredDevice) prometheus.Labels {
 	return prometheus.Labels{
 		"hub_id":      d.hubID,
 		"hub_name":    d.hubName,
-		"room_id":     device.roomID,
-		"room_name":   device.roomName,
-		"device_id":   deviceID,
-		"device_name": device.deviceName,
-		"device_type": device.deviceType,
+		"room_id":     device.RoomID,
+		"room_name":   device.RoomName,
+		"device_id":   device.ID,
+		"device_name": device.Name,
+		"device_type": device.Type,
 	}
 }
 
@@ -0,0 +1,115 @@
+package dirigera
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/mdns"
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
+)
+
+// DiscoverHub searches the network for hubs announcing the service _ihsp._tcp via mDNS.
+// If an ID or a name is configured, the hub with matching serial number (ID) or host name is selected.
+// Otherwise, exactly one hub must be found.
+func DiscoverHub(discovery config.DiscoveryConfig) (address string, port int, err error) {
+	hubs, err := scanHubs()
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: error scanning for hubs: %v", ErrConnectivity, err)
+	}
+
+	var candidates []client.DirigeraHub
+	for _, hub := range hubs {
+		if matchesDiscovery(hub, discovery) {
+			candidates = append(candidates, hub)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		if len(hubs) == 0 {
+			return "", 0, fmt.Errorf("%w: no hub found via mDNS", ErrConnectivity)
+		}
+		return "", 0, fmt.Errorf("%w: none of the %d hubs found via mDNS matches id %q or name %q", ErrConnectivity, len(hubs), discovery.ID, discovery.Name)
+	case 1:
+		return candidates[0].Address, candidates[0].Port, nil
+	default:
+		names := make([]string, 0, len(candidates))
+		for _, hub := range candidates {
+			names = append(names, fmt.Sprintf("%s (id %s, address %s)", hub.HostName, hub.SerialNumber, hub.Address))
+		}
+		return "", 0, fmt.Errorf("%w: found %d hubs via mDNS, configure hub.discovery.id or hub.discovery.name to select one: %s", ErrConfiguration, len(candidates), strings.Join(names, ", "))
+	}
+}
+
+// scanHubs queries the hubs announcing the service via mDNS. The entries are collected until the query returned,
+// the query drops entries while they are not received.
+func scanHubs() ([]client.DirigeraHub, error) {
+	entries := make(chan *mdns.ServiceEntry, 4)
+	collected := make(chan []client.DirigeraHub, 1)
+	go func() {
+		var hubs []client.DirigeraHub
+		for entry := range entries {
+			info := make(map[string]string)
+			for _, field := range entry.InfoFields {
+				if name, value, found := strings.Cut(field, "="); found {
+					info[name] = value
+				}
+			}
+			if info["type"] != "DIRIGERA" || entry.AddrV4 == nil {
+				continue
+			}
+			hubs = append(hubs, client.DirigeraHub{
+				HostName:        info["hostname"],
+				Address:         entry.AddrV4.String(),
+				Port:            entry.Port,
+				FirmwareVersion: info["sv"],
+				SerialNumber:    info["uuid"],
+			})
+		}
+		collected <- hubs
+	}()
+
+	params := mdns.DefaultParams("_ihsp._tcp")
+	params.Entries = entries
+	params.DisableIPv6 = true
+	params.Logger = log.New(io.Discard, "", 0) // the query logs every unparsable packet
+	err := mdns.Query(params)
+	close(entries)
+	return <-collected, err
+}
+
+func matchesDiscovery(hub client.DirigeraHub, discovery config.DiscoveryConfig) bool {
+	if discovery.ID != "" {
+		id, _ := normalizeID(discovery.ID)
+		if !strings.EqualFold(hub.SerialNumber, id) {
+			return false
+		}
+	}
+	if discovery.Name != "" {
+		hostName := strings.TrimSuffix(hub.HostName, ".")
+		hostName = strings.TrimSuffix(hostName, ".local")
+		if !strings.EqualFold(hostName, discovery.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveAddress returns the configuration with address and port of the discovered hub if no address is configured.
+func resolveAddress(cfg config.HubConfig) (config.HubConfig, error) {
+	if cfg.Address != "" {
+		return cfg, nil
+	}
+	address, port, err := DiscoverHub(cfg.Discovery)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Address = address
+	if port != 0 {
+		cfg.Port = port
+	}
+	return cfg, nil
+}
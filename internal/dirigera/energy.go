@@ -0,0 +1,227 @@
+package dirigera
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+)
+
+// energyState contains the offset for stitching the energy readings of an outlet across resets into a monotonic
+// series
+type energyState struct {
+	Offset    float64   `json:"offset"`              // energy consumed before the last reset (kWh)
+	Last      float64   `json:"last"`                // last reading of the outlet (kWh)
+	LastReset time.Time `json:"last_reset,omitzero"` // time of the last reset reported by the outlet
+}
+
+// energyReset is the last reset of the energy reading reported by an outlet
+type energyReset struct {
+	time        time.Time
+	consumed    float64 // reading before the reset (kWh)
+	hasConsumed bool
+}
+
+// powerSample is the last power reading of an outlet used for estimating the energy consumed
+type powerSample struct {
+	time  time.Time
+	power float64 // watts
+}
+
+// energyMetric contains the energy consumed at outlets as counters
+type energyMetric struct {
+	consumedMetric  *prometheus.CounterVec
+	estimatedMetric *prometheus.CounterVec
+	lastResetMetric *prometheus.GaugeVec
+
+	// exported contains the values of the counters, which can only be increased, key: hub ID and device ID
+	exported      map[[2]string]float64
+	exportedMutex sync.Mutex
+}
+
+func newEnergyMetric(registerer prometheus.Registerer) *energyMetric {
+	metric := &energyMetric{
+		consumedMetric: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ikea",
+			Subsystem: "outlet",
+			Name:      "energy_consumed_kwh_total",
+			Help:      "Energy consumed at an outlet as reported by the outlet, continued across resets (kilowatt hours)",
+		}, metricLabelNames),
+		estimatedMetric: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ikea",
+			Subsystem: "outlet",
+			Name:      "estimated_energy_consumed_kwh_total",
+			Help:      "Energy consumed at an outlet not reporting it, estimated by the exporter from the power readings (kilowatt hours)",
+		}, metricLabelNames),
+		lastResetMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "outlet",
+			Name:      "energy_last_reset_timestamp_seconds",
+			Help:      "Last time the energy reading of an outlet was reset (Unix timestamp in seconds)",
+		}, metricLabelNames),
+		exported: make(map[[2]string]float64),
+	}
+	registerer.MustRegister(metric.consumedMetric)
+	registerer.MustRegister(metric.estimatedMetric)
+	registerer.MustRegister(metric.lastResetMetric)
+
+	return metric
+}
+
+// setConsumed increases the counter to the given value
+func (m *energyMetric) setConsumed(consumed float64, labels prometheus.Labels) {
+	m.exportedMutex.Lock()
+	defer m.exportedMutex.Unlock()
+
+	key := [2]string{labels["hub_id"], labels["device_id"]}
+	counter := m.consumedMetric.With(labels)
+	if exported := m.exported[key]; consumed > exported {
+		counter.Add(consumed - exported)
+		m.exported[key] = consumed
+	}
+}
+
+func (m *energyMetric) addEstimated(energy float64, labels prometheus.Labels) {
+	m.estimatedMetric.With(labels).Add(energy)
+}
+
+func (m *energyMetric) update(device client.Device, labels prometheus.Labels) {
+	if lastReset, hasLastReset := lastEnergyReset(device); hasLastReset {
+		m.lastResetMetric.With(labels).Set(float64(lastReset.Unix()))
+	}
+}
+
+// lastEnergyReset returns the time of the last reset of the energy reading reported by the outlet
+func lastEnergyReset(device client.Device) (time.Time, bool) {
+	lastReset, hasLastReset := device.Attributes["timeOfLastEnergyReset"].(string)
+	if !hasLastReset {
+		return time.Time{}, false
+	}
+	timestamp, err := time.Parse(time.RFC3339, lastReset)
+	return timestamp, err == nil
+}
+
+// energyResetOf returns the last reset of the energy reading with the reading before the reset reported by the outlet
+func energyResetOf(device client.Device) energyReset {
+	lastReset, _ := lastEnergyReset(device)
+	consumed, hasConsumed := device.Attributes["energyConsumedAtLastReset"].(float64)
+	return energyReset{time: lastReset, consumed: consumed, hasConsumed: hasConsumed}
+}
+
+func (m *energyMetric) remove(labels prometheus.Labels) {
+	m.exportedMutex.Lock()
+	defer m.exportedMutex.Unlock()
+
+	m.consumedMetric.DeletePartialMatch(labels)
+	m.estimatedMetric.DeletePartialMatch(labels)
+	m.lastResetMetric.DeletePartialMatch(labels)
+	for key := range m.exported {
+		// Without device_id the entries of all devices of the hub are deleted
+		if key[0] == labels["hub_id"] && (key[1] == labels["device_id"] || labels["device_id"] == "") {
+			delete(m.exported, key)
+		}
+	}
+}
+
+// trackEnergy returns the energy consumed at the outlet, the readings are continued with an offset after a reset.
+// A reset is detected by a changed time of the last reset - also a reset while the exporter was not running - and
+// continued with the reading before the reset reported by the outlet. Without it, a reset is only detected by a
+// decreasing reading and continued with the last reading. The registry must be locked by updateMutex.
+func (d *dirigeraClient) trackEnergy(deviceID string, reading float64, lastReset energyReset) float64 {
+	d.loadState()
+	state, isKnown := d.energy[deviceID]
+	resetChanged := !lastReset.time.IsZero() && !lastReset.time.Equal(state.LastReset)
+	reset := false
+	switch {
+	case !isKnown:
+	case resetChanged && !state.LastReset.IsZero() && lastReset.hasConsumed:
+		reset = true
+		state.Offset += lastReset.consumed
+	case reading < state.Last:
+		reset = true
+		state.Offset += state.Last
+	}
+	if reset {
+		fmt.Printf("Energy reading of device %s reset from %.3f to %.3f kWh\n", deviceID, state.Last, reading)
+	}
+	switch {
+	case resetChanged:
+		state.LastReset = lastReset.time
+	case reset:
+		// The time of the reset is not reported yet, it must not be detected as another reset when received
+		state.LastReset = time.Time{}
+	}
+	if !isKnown || reset || resetChanged {
+		d.stateChanged = true
+	}
+	state.Last = reading
+	d.energy[deviceID] = state
+	return state.Offset + reading
+}
+
+// updateEnergy updates the energy counter of an outlet - after relabeling from the known readings, because the event
+// contains only the changed values. The registry must be locked by updateMutex.
+func (d *dirigeraClient) updateEnergy(registered RegisteredDevice, device client.Device, relabeled bool, labels prometheus.Labels) {
+	d.metrics.energy.update(device, labels)
+	if reading, hasReading := device.Attributes["totalEnergyConsumed"].(float64); hasReading {
+		// The known state contains the last reset if it was not changed by the event
+		lastReset := energyResetOf(registered.States[device.ID])
+		d.metrics.energy.setConsumed(d.trackEnergy(registered.ID, reading, lastReset), labels)
+		return
+	}
+	if !relabeled {
+		return
+	}
+	for _, state := range registered.States {
+		d.metrics.energy.update(state, labels)
+	}
+	if state, isKnown := d.energy[registered.ID]; isKnown {
+		d.metrics.energy.setConsumed(state.Offset+state.Last, labels)
+	}
+}
+
+// updateEstimatedEnergy integrates the power readings of an outlet not reporting the energy consumed over time
+// (trapezoidal rule). Events and resyncs are integrated with the time they are applied, which is the same time base
+// for both and does not depend on the scrapes. The registry must be locked by updateMutex.
+func (d *dirigeraClient) updateEstimatedEnergy(registered RegisteredDevice, device client.Device, at time.Time, relabeled bool, labels prometheus.Labels) {
+	d.loadState()
+	if _, hasReading := d.energy[registered.ID]; hasReading || registered.Type != "outlet" {
+		return
+	}
+	if relabeled {
+		if estimated, isKnown := d.estimated[registered.ID]; isKnown {
+			d.metrics.energy.addEstimated(estimated, labels)
+		}
+	}
+	state := registered.States[device.ID] // contains the power of the outlet if not changed by the event
+	power, hasPower := state.Attributes["currentActivePower"].(float64)
+	if !hasPower {
+		return
+	}
+	if !state.IsReachable {
+		// The power is unknown until the outlet is reachable again, so the gap is not integrated
+		delete(d.powerSamples, registered.ID)
+		return
+	}
+
+	last, hasLast := d.powerSamples[registered.ID]
+	d.powerSamples[registered.ID] = powerSample{time: at, power: power}
+	if !hasLast {
+		d.metrics.energy.addEstimated(0, labels)
+		return
+	}
+	energy := (last.power + power) / 2 * at.Sub(last.time).Hours() / 1000
+	d.estimated[registered.ID] += energy
+	d.metrics.energy.addEstimated(energy, labels)
+}
+
+// resetPowerSamples restarts the estimation of the energy consumed, e.g. after a reconnect events might have been
+// missed
+func (d *dirigeraClient) resetPowerSamples() {
+	d.updateMutex.Lock()
+	defer d.updateMutex.Unlock()
+
+	clear(d.powerSamples)
+}
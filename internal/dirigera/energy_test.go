@@ -0,0 +1,237 @@
+package dirigera
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
+)
+
+func newTestClient(stateFile string) *dirigeraClient {
+	return &dirigeraClient{
+		label:        "test",
+		cfg:          config.HubConfig{StateFile: stateFile},
+		hubID:        "hub",
+		hubName:      "Home",
+		registry:     newRegistry(),
+		metrics:      NewMetrics(prometheus.NewRegistry()),
+		powerSamples: make(map[string]powerSample),
+		estimated:    make(map[string]float64),
+	}
+}
+
+// outlet returns the state of an outlet with the given attributes
+func outlet(reachable bool, attributes map[string]interface{}) client.Device {
+	attributes["customName"] = "Outlet"
+	return client.Device{
+		ID:           "outlet_1",
+		Type:         "outlet",
+		DetailedType: "outlet",
+		IsReachable:  reachable,
+		Room:         client.Room{ID: "room", Name: "Kitchen"},
+		Attributes:   attributes,
+	}
+}
+
+// registerOutlet registers the state of the outlet as received by the resync
+func registerOutlet(t *testing.T, d *dirigeraClient, state client.Device) RegisteredDevice {
+	t.Helper()
+	if _, _, err := d.registry.register(state); err != nil {
+		t.Fatal(err)
+	}
+	registered, _ := d.registry.updateState(state, false)
+	return registered
+}
+
+type energyReading struct {
+	reading   float64
+	lastReset energyReset
+}
+
+func TestTrackEnergy(t *testing.T) {
+	first := energyReset{time: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	second := energyReset{time: first.time.Add(24 * time.Hour), consumed: 7, hasConsumed: true}
+	secondWithoutReading := energyReset{time: second.time}
+	tests := []struct {
+		name     string
+		readings []energyReading
+		want     float64
+	}{
+		{
+			name:     "increasing readings",
+			readings: []energyReading{{1, first}, {2, first}, {3.5, first}},
+			want:     3.5,
+		},
+		{
+			name:     "decreasing reading without reset time",
+			readings: []energyReading{{5, energyReset{}}, {0.5, energyReset{}}, {1, energyReset{}}},
+			want:     6,
+		},
+		{
+			name:     "changed reset time continued with the reading before the reset",
+			readings: []energyReading{{5, first}, {6, second}},
+			want:     13,
+		},
+		{
+			name:     "changed reset time without the reading before the reset",
+			readings: []energyReading{{5, first}, {0.5, secondWithoutReading}},
+			want:     5.5,
+		},
+		{
+			name:     "reset time received after the decreasing reading",
+			readings: []energyReading{{5, first}, {0.1, first}, {0.2, second}},
+			want:     5.2,
+		},
+		{
+			name:     "first reset time received",
+			readings: []energyReading{{5, energyReset{}}, {6, first}},
+			want:     6,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newTestClient("")
+			var got float64
+			for _, reading := range test.readings {
+				got = d.trackEnergy("outlet", reading.reading, reading.lastReset)
+			}
+			if got != test.want {
+				t.Errorf("trackEnergy() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTrackEnergyAfterRestart(t *testing.T) {
+	first := energyReset{time: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	second := energyReset{time: first.time.Add(24 * time.Hour), consumed: 8, hasConsumed: true}
+	tests := []struct {
+		name    string
+		restart energyReading
+		want    float64
+	}{
+		{name: "no reset", restart: energyReading{7, first}, want: 7},
+		{name: "decreasing reading", restart: energyReading{0.5, energyReset{time: second.time}}, want: 5.5},
+		{name: "reset continued with the reading before the reset", restart: energyReading{6, second}, want: 14},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stateFile := filepath.Join(t.TempDir(), "state.json")
+			before := newTestClient(stateFile)
+			before.trackEnergy("outlet", 5, first)
+			before.saveState(true)
+
+			after := newTestClient(stateFile)
+			if got := after.trackEnergy("outlet", test.restart.reading, test.restart.lastReset); got != test.want {
+				t.Errorf("trackEnergy() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+type powerReading struct {
+	offset    time.Duration // since the first reading
+	power     float64       // watts
+	reachable bool
+}
+
+func TestUpdateEstimatedEnergy(t *testing.T) {
+	tests := []struct {
+		name     string
+		readings []powerReading
+		want     float64 // kWh
+	}{
+		{
+			name:     "constant power",
+			readings: []powerReading{{0, 100, true}, {time.Hour, 100, true}, {2 * time.Hour, 100, true}},
+			want:     0.2,
+		},
+		{
+			name:     "changing power",
+			readings: []powerReading{{0, 0, true}, {time.Hour, 100, true}},
+			want:     0.05,
+		},
+		{
+			name: "unreachable gap",
+			readings: []powerReading{{0, 100, true}, {time.Hour, 100, false}, {2 * time.Hour, 100, true},
+				{3 * time.Hour, 100, true}},
+			want: 0.1,
+		},
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newTestClient("")
+			var labels prometheus.Labels
+			for _, reading := range test.readings {
+				state := outlet(reading.reachable, map[string]interface{}{"currentActivePower": reading.power})
+				registered := registerOutlet(t, d, state)
+				labels = d.createLabels(registered)
+				d.updateEstimatedEnergy(registered, state, start.Add(reading.offset), false, labels)
+			}
+			got := testutil.ToFloat64(d.metrics.energy.estimatedMetric.With(labels))
+			if diff := got - test.want; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("estimated energy = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRelabelEnergy(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]interface{}
+		metric     func(m *energyMetric) *prometheus.CounterVec
+		want       float64
+	}{
+		{
+			name:       "reported energy",
+			attributes: map[string]interface{}{"totalEnergyConsumed": 5.0},
+			metric:     func(m *energyMetric) *prometheus.CounterVec { return m.consumedMetric },
+			want:       5,
+		},
+		{
+			name:       "estimated energy",
+			attributes: map[string]interface{}{"currentActivePower": 100.0},
+			metric:     func(m *energyMetric) *prometheus.CounterVec { return m.estimatedMetric },
+			want:       0.1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newTestClient("")
+			start := time.Now()
+			for _, at := range []time.Time{start, start.Add(time.Hour)} {
+				registered := registerOutlet(t, d, outlet(true, test.attributes))
+				labels := d.createLabels(registered)
+				d.updateEnergy(registered, registered.States["outlet_1"], false, labels)
+				d.updateEstimatedEnergy(registered, registered.States["outlet_1"], at, false, labels)
+			}
+
+			// The relabeling integrates the power until now, which is not part of the test
+			delete(d.powerSamples, "outlet")
+			d.updateMutex.Lock()
+			d.relabelHub("hub", "House")
+			d.updateMutex.Unlock()
+
+			// The series with the old hub name must be deleted, only the series with the new one is left
+			vec := test.metric(d.metrics.energy)
+			if count := testutil.CollectAndCount(vec); count != 1 {
+				t.Fatalf("%d series after relabeling, want 1", count)
+			}
+			registered, _ := d.registry.Get("outlet")
+			labels := d.createLabels(registered)
+			if labels["hub_name"] != "House" {
+				t.Fatalf("hub_name = %q after relabeling, want %q", labels["hub_name"], "House")
+			}
+			got := testutil.ToFloat64(vec.With(labels))
+			if diff := got - test.want; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("energy after relabeling = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -10,7 +10,7 @@ type environmentSensorMetric struct {
 	humidityMetric    *prometheus.GaugeVec
 }
 
-func newEnvironmentSensorMetric() dirigeraMetric {
+func newEnvironmentSensorMetric(registerer prometheus.Registerer) dirigeraMetric {
 	metric := &environmentSensorMetric{
 		temperatureMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: "ikea",
@@ -25,8 +25,8 @@ func newEnvironmentSensorMetric() dirigeraMetric {
 			Help:      "Current relative humidity measured by an environment sensor (percent)",
 		}, metricLabelNames),
 	}
-	prometheus.MustRegister(metric.temperatureMetric)
-	prometheus.MustRegister(metric.humidityMetric)
+	registerer.MustRegister(metric.temperatureMetric)
+	registerer.MustRegister(metric.humidityMetric)
 
 	return metric
 }
@@ -39,3 +39,8 @@ func (m *environmentSensorMetric) update(device client.Device, labels prometheus
 		m.humidityMetric.With(labels).Set(humidity)
 	}
 }
+
+func (m *environmentSensorMetric) remove(labels prometheus.Labels) {
+	m.temperatureMetric.DeletePartialMatch(labels)
+	m.humidityMetric.DeletePartialMatch(labels)
+}
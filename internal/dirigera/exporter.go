@@ -0,0 +1,205 @@
+package dirigera
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// exporterMetric contains the metrics about the exporter itself and its connection to the hub
+type exporterMetric struct {
+	fingerprintMismatchMetric *prometheus.GaugeVec
+	reconnectAttemptsMetric   *prometheus.CounterVec
+	connectedMetric           *prometheus.GaugeVec
+	lastConnectMetric         *prometheus.GaugeVec
+	driftMetric               *prometheus.GaugeVec
+	driftTotalMetric          *prometheus.CounterVec
+	lastEventMetric           *prometheus.GaugeVec
+	hubUpMetric               *prometheus.GaugeVec
+	requestDurationMetric     *prometheus.HistogramVec
+	eventsMetric              *prometheus.CounterVec
+	updateFailuresMetric      *prometheus.CounterVec
+	cacheHitsMetric           *prometheus.CounterVec
+	cacheMissesMetric         *prometheus.CounterVec
+	cacheSizeMetric           *prometheus.GaugeVec
+}
+
+func newExporterMetric(registerer prometheus.Registerer) *exporterMetric {
+	metric := &exporterMetric{
+		fingerprintMismatchMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "tls_fingerprint_mismatch",
+			Help:      "Certificate presented by the hub does not match the pinned fingerprint (0 = match, 1 = mismatch)",
+		}, []string{"hub"}),
+		reconnectAttemptsMetric: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "hub_reconnect_attempts_total",
+			Help:      "Number of attempts to reconnect to the hub after the event loop failed",
+		}, []string{"hub"}),
+		connectedMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "hub_connected",
+			Help:      "Current state of the event connection to the hub (0 = disconnected, 1 = connected)",
+		}, []string{"hub"}),
+		lastConnectMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "hub_last_connect_timestamp_seconds",
+			Help:      "Last time the event connection to the hub was established (Unix timestamp in seconds)",
+		}, []string{"hub"}),
+		driftMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "resync_drifted_values",
+			Help:      "Number of values corrected by the last periodic resync because events were missed",
+		}, []string{"hub"}),
+		driftTotalMetric: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "resync_drifted_values_total",
+			Help:      "Number of values corrected by periodic resyncs because events were missed",
+		}, []string{"hub"}),
+		lastEventMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "last_event_timestamp_seconds",
+			Help:      "Last time an event was received from the hub (Unix timestamp in seconds)",
+		}, []string{"hub"}),
+		hubUpMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "hub_up",
+			Help:      "Health of the hub (0 = down, 1 = up), the reason label categorizes the cause (ok, pending, unauthorized, tls_mismatch, unreachable, protocol, silent)",
+		}, []string{"hub", "reason"}),
+		requestDurationMetric: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "hub_request_duration_seconds",
+			Help:      "Duration of the requests to the hub by operation and status (ok, HTTP status code or error)",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"hub", "operation", "status"}),
+		eventsMetric: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "events_received_total",
+			Help:      "Number of events received from the hub by event type",
+		}, []string{"hub", "type"}),
+		updateFailuresMetric: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "update_failures_total",
+			Help:      "Number of device updates not applied to the metrics because the device details could not be read",
+		}, []string{"hub"}),
+		cacheHitsMetric: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "cache_hits_total",
+			Help:      "Number of device updates with name and room of the device taken from the device cache",
+		}, []string{"hub"}),
+		cacheMissesMetric: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "cache_misses_total",
+			Help:      "Number of device updates requiring to read the device details from the hub",
+		}, []string{"hub"}),
+		cacheSizeMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "exporter",
+			Name:      "cache_devices",
+			Help:      "Number of devices in the device cache",
+		}, []string{"hub"}),
+	}
+	registerer.MustRegister(metric.fingerprintMismatchMetric)
+	registerer.MustRegister(metric.reconnectAttemptsMetric)
+	registerer.MustRegister(metric.connectedMetric)
+	registerer.MustRegister(metric.lastConnectMetric)
+	registerer.MustRegister(metric.driftMetric)
+	registerer.MustRegister(metric.driftTotalMetric)
+	registerer.MustRegister(metric.lastEventMetric)
+	registerer.MustRegister(metric.hubUpMetric)
+	registerer.MustRegister(metric.requestDurationMetric)
+	registerer.MustRegister(metric.eventsMetric)
+	registerer.MustRegister(metric.updateFailuresMetric)
+	registerer.MustRegister(metric.cacheHitsMetric)
+	registerer.MustRegister(metric.cacheMissesMetric)
+	registerer.MustRegister(metric.cacheSizeMetric)
+
+	return metric
+}
+
+func (m *exporterMetric) setFingerprintMismatch(hub string, mismatch bool) {
+	var value float64 = 0
+	if mismatch {
+		value = 1
+	}
+	m.fingerprintMismatchMetric.With(prometheus.Labels{"hub": hub}).Set(value)
+}
+
+// addHub initializes the connection metrics of the hub, the label is the name of the hub configuration
+func (m *exporterMetric) addHub(hub string) {
+	m.fingerprintMismatchMetric.With(prometheus.Labels{"hub": hub}).Set(0)
+	m.reconnectAttemptsMetric.With(prometheus.Labels{"hub": hub}).Add(0)
+	m.connectedMetric.With(prometheus.Labels{"hub": hub}).Set(0)
+	m.driftTotalMetric.With(prometheus.Labels{"hub": hub}).Add(0)
+	m.updateFailuresMetric.With(prometheus.Labels{"hub": hub}).Add(0)
+	m.cacheHitsMetric.With(prometheus.Labels{"hub": hub}).Add(0)
+	m.cacheMissesMetric.With(prometheus.Labels{"hub": hub}).Add(0)
+	m.cacheSizeMetric.With(prometheus.Labels{"hub": hub}).Set(0)
+}
+
+func (m *exporterMetric) countReconnectAttempt(hub string) {
+	m.reconnectAttemptsMetric.With(prometheus.Labels{"hub": hub}).Inc()
+}
+
+func (m *exporterMetric) setConnected(hub string, connected bool) {
+	var value float64 = 0
+	if connected {
+		value = 1
+		m.lastConnectMetric.With(prometheus.Labels{"hub": hub}).SetToCurrentTime()
+	}
+	m.connectedMetric.With(prometheus.Labels{"hub": hub}).Set(value)
+}
+
+func (m *exporterMetric) setDrift(hub string, drifted int) {
+	m.driftMetric.With(prometheus.Labels{"hub": hub}).Set(float64(drifted))
+	m.driftTotalMetric.With(prometheus.Labels{"hub": hub}).Add(float64(drifted))
+}
+
+func (m *exporterMetric) countEvent(hub, eventType string) {
+	m.lastEventMetric.With(prometheus.Labels{"hub": hub}).SetToCurrentTime()
+	m.eventsMetric.With(prometheus.Labels{"hub": hub, "type": eventType}).Inc()
+}
+
+// setHubUp replaces the series of the hub with the series for the current reason
+func (m *exporterMetric) setHubUp(hub string, reason Reason) {
+	var value float64 = 0
+	if reason == ReasonOK {
+		value = 1
+	}
+	m.hubUpMetric.DeletePartialMatch(prometheus.Labels{"hub": hub})
+	m.hubUpMetric.With(prometheus.Labels{"hub": hub, "reason": string(reason)}).Set(value)
+}
+
+func (m *exporterMetric) observeRequest(hub, operation, status string, duration time.Duration) {
+	m.requestDurationMetric.With(prometheus.Labels{"hub": hub, "operation": operation, "status": status}).Observe(duration.Seconds())
+}
+
+func (m *exporterMetric) countUpdateFailure(hub string) {
+	m.updateFailuresMetric.With(prometheus.Labels{"hub": hub}).Inc()
+}
+
+// countCacheLookup counts a hit if the device was found in the cache, otherwise a miss
+func (m *exporterMetric) countCacheLookup(hub string, hit bool) {
+	if hit {
+		m.cacheHitsMetric.With(prometheus.Labels{"hub": hub}).Inc()
+	} else {
+		m.cacheMissesMetric.With(prometheus.Labels{"hub": hub}).Inc()
+	}
+}
+
+func (m *exporterMetric) setCacheSize(hub string, size int) {
+	m.cacheSizeMetric.With(prometheus.Labels{"hub": hub}).Set(float64(size))
+}
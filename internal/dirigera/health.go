@@ -0,0 +1,116 @@
+package dirigera
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Reason is the category of the health state of a hub, it is used as label of ikea_exporter_hub_up and as error
+// code in the ready check
+type Reason string
+
+const (
+	ReasonOK            Reason = "ok"
+	ReasonPending       Reason = "pending" // no connection attempted yet
+	ReasonUnauthorized  Reason = "unauthorized"
+	ReasonTLSMismatch   Reason = "tls_mismatch"
+	ReasonUnreachable   Reason = "unreachable"
+	ReasonProtocol      Reason = "protocol"
+	ReasonConfiguration Reason = "configuration"
+	ReasonSilent        Reason = "silent"
+)
+
+var reasonHints = map[Reason]string{
+	ReasonPending:       "waiting for the first connection to the hub",
+	ReasonUnauthorized:  "the access token was rejected by the hub, create a new one with the pair command",
+	ReasonTLSMismatch:   "the hub presented an unexpected certificate, verify the fingerprint with the check command",
+	ReasonUnreachable:   "check the address of the hub and the network connection",
+	ReasonProtocol:      "the hub sent an unexpected response, see the log for details",
+	ReasonConfiguration: "several hubs were found, select one by hub.discovery.id or hub.discovery.name",
+	ReasonSilent:        "the hub sends no events over the open connection, restarting the hub may help",
+}
+
+// HubError is returned by the health check of a hub, it categorizes the cause by the reason
+type HubError struct {
+	Reason Reason
+	Start  time.Time // since when the hub is in this state
+	Cause  error
+}
+
+func (e *HubError) Error() string {
+	return e.Cause.Error()
+}
+
+func (e *HubError) Unwrap() error {
+	return e.Cause
+}
+
+// Code returns the reason as error code for the ready check of the web server
+func (e *HubError) Code() string {
+	return string(e.Reason)
+}
+
+// Since returns the time since when the hub is in this state for the ready check of the web server
+func (e *HubError) Since() time.Time {
+	return e.Start
+}
+
+// Hint returns a suggestion for fixing the cause for the ready check of the web server
+func (e *HubError) Hint() string {
+	return reasonHints[e.Reason]
+}
+
+// classify returns the reason for the error, ReasonOK if nil
+func classify(err error) Reason {
+	var silenceError *EventSilenceError
+	var initialSyncError *InitialSyncError
+	var netError net.Error
+	switch {
+	case err == nil:
+		return ReasonOK
+	case errors.As(err, &silenceError):
+		return ReasonSilent
+	// The hub client reports a mismatch of the fingerprint only in the error message
+	case errors.Is(err, ErrTLS) || strings.Contains(err.Error(), "fingerprint does not match"):
+		return ReasonTLSMismatch
+	case errors.Is(err, ErrAuthorization) || errors.Is(classifyStatus(err), ErrAuthorization):
+		return ReasonUnauthorized
+	case errors.Is(err, ErrConfiguration):
+		return ReasonConfiguration
+	case errors.Is(err, ErrConnectivity) || errors.As(err, &netError) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || strings.Contains(err.Error(), "abnormal closure"):
+		return ReasonUnreachable
+	case errors.As(err, &initialSyncError) && initialSyncError.Cause == nil:
+		return ReasonPending
+	default:
+		return ReasonProtocol
+	}
+}
+
+// recordHealth categorizes the cause and updates the health state of the hub, which is reported by the metric
+// ikea_exporter_hub_up. Returns a HubError with the cause, nil if the hub is healthy.
+func (d *dirigeraClient) recordHealth(cause error) error {
+	reason := classify(cause)
+
+	d.hubMutex.Lock()
+	if reason != d.healthReason {
+		d.healthReason = reason
+		d.healthSince = time.Now()
+		d.metrics.exporter.setHubUp(d.label, reason)
+	}
+	since := d.healthSince
+	d.hubMutex.Unlock()
+
+	if cause == nil {
+		return nil
+	}
+	return &HubError{Reason: reason, Start: since, Cause: cause}
+}
+
+// updateHealth updates the health state after changes, it is also updated by every ready check
+func (d *dirigeraClient) updateHealth() {
+	_ = d.Health()
+}
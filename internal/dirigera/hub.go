@@ -0,0 +1,155 @@
+package dirigera
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+)
+
+var hubLabelNames = []string{"hub_id", "hub_name"}
+
+// hubMetric contains the metrics of the hub (gateway) itself
+type hubMetric struct {
+	infoMetric               *prometheus.GaugeVec
+	reachableMetric          *prometheus.GaugeVec
+	uptimeMetric             *prometheus.GaugeVec
+	otaUpdateAvailableMetric *prometheus.GaugeVec
+	otaStateMetric           *prometheus.GaugeVec
+	backendConnectedMetric   *prometheus.GaugeVec
+	devicesMetric            *prometheus.GaugeVec
+}
+
+func newHubMetric(registerer prometheus.Registerer) *hubMetric {
+	metric := &hubMetric{
+		infoMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "hub",
+			Name:      "info",
+			Help:      "Information about the hub, the value is always 1",
+		}, append(hubLabelNames, "model", "manufacturer", "firmware_version", "hardware_version", "serial_number")),
+		reachableMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "hub",
+			Name:      "reachable",
+			Help:      "Reachability of the hub as reported by itself (0 = unreachable, 1 = reachable)",
+		}, hubLabelNames),
+		uptimeMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "hub",
+			Name:      "uptime_seconds",
+			Help:      "Time since the start of the hub (seconds)",
+		}, hubLabelNames),
+		otaUpdateAvailableMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "hub",
+			Name:      "ota_update_available",
+			Help:      "Firmware update available for the hub (0 = up to date, 1 = update available)",
+		}, hubLabelNames),
+		otaStateMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "hub",
+			Name:      "ota_state",
+			Help:      "Current state of the firmware update of the hub, the value is always 1",
+		}, append(hubLabelNames, "state")),
+		backendConnectedMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "hub",
+			Name:      "backend_connected",
+			Help:      "Connection of the hub to the IKEA cloud (0 = disconnected, 1 = connected)",
+		}, hubLabelNames),
+		devicesMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "hub",
+			Name:      "devices",
+			Help:      "Number of devices paired with the hub by device type",
+		}, append(hubLabelNames, "device_type")),
+	}
+	registerer.MustRegister(metric.infoMetric)
+	registerer.MustRegister(metric.reachableMetric)
+	registerer.MustRegister(metric.uptimeMetric)
+	registerer.MustRegister(metric.otaUpdateAvailableMetric)
+	registerer.MustRegister(metric.otaStateMetric)
+	registerer.MustRegister(metric.backendConnectedMetric)
+	registerer.MustRegister(metric.devicesMetric)
+
+	return metric
+}
+
+// update sets the metrics from the hub status, which must contain all known attributes because the info labels
+// are replaced
+func (m *hubMetric) update(hub client.Device, labels prometheus.Labels) {
+	infoLabels := prometheus.Labels{
+		"model":            stringAttribute(hub, "model"),
+		"manufacturer":     stringAttribute(hub, "manufacturer"),
+		"firmware_version": stringAttribute(hub, "firmwareVersion"),
+		"hardware_version": stringAttribute(hub, "hardwareVersion"),
+		"serial_number":    stringAttribute(hub, "serialNumber"),
+	}
+	for name, value := range labels {
+		infoLabels[name] = value
+	}
+	m.infoMetric.DeletePartialMatch(labels)
+	m.infoMetric.With(infoLabels).Set(1)
+
+	var value float64 = 0
+	if hub.IsReachable {
+		value = 1
+	}
+	m.reachableMetric.With(labels).Set(value)
+	if uptime, hasUptime := hub.Attributes["uptime"].(float64); hasUptime {
+		m.uptimeMetric.With(labels).Set(uptime)
+	}
+	if otaStatus, hasOtaStatus := hub.Attributes["otaStatus"].(string); hasOtaStatus {
+		var value float64 = 0
+		if otaStatus == "updateAvailable" {
+			value = 1
+		}
+		m.otaUpdateAvailableMetric.With(labels).Set(value)
+	}
+	if otaState, hasOtaState := hub.Attributes["otaState"].(string); hasOtaState {
+		m.otaStateMetric.DeletePartialMatch(labels)
+		m.otaStateMetric.With(prometheus.Labels{"hub_id": labels["hub_id"], "hub_name": labels["hub_name"], "state": otaState}).Set(1)
+	}
+	if connected, hasConnected := hub.Attributes["backendConnected"].(bool); hasConnected {
+		var value float64 = 0
+		if connected {
+			value = 1
+		}
+		m.backendConnectedMetric.With(labels).Set(value)
+	}
+}
+
+// setDevices replaces the numbers of paired devices, key: device type
+func (m *hubMetric) setDevices(devices map[string]int, labels prometheus.Labels) {
+	m.devicesMetric.DeletePartialMatch(labels)
+	for deviceType, count := range devices {
+		m.devicesMetric.With(prometheus.Labels{"hub_id": labels["hub_id"], "hub_name": labels["hub_name"], "device_type": deviceType}).Set(float64(count))
+	}
+}
+
+func (m *hubMetric) remove(labels prometheus.Labels) {
+	m.infoMetric.DeletePartialMatch(labels)
+	m.reachableMetric.DeletePartialMatch(labels)
+	m.uptimeMetric.DeletePartialMatch(labels)
+	m.otaUpdateAvailableMetric.DeletePartialMatch(labels)
+	m.otaStateMetric.DeletePartialMatch(labels)
+	m.backendConnectedMetric.DeletePartialMatch(labels)
+	m.devicesMetric.DeletePartialMatch(labels)
+}
+
+func stringAttribute(device client.Device, name string) string {
+	value, _ := device.Attributes[name].(string)
+	return value
+}
+
+// updateHub merges the hub status received by a request or an event into the known status and updates the metrics
+func (d *dirigeraClient) updateHub(status client.Device) {
+	d.updateMutex.Lock()
+	defer d.updateMutex.Unlock()
+
+	d.hubStatus = mergeDevice(d.hubStatus, status)
+	d.metrics.hub.update(d.hubStatus, d.hubLabels())
+}
+
+func (d *dirigeraClient) hubLabels() prometheus.Labels {
+	return prometheus.Labels{"hub_id": d.hubID, "hub_name": d.hubName}
+}
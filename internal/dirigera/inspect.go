@@ -0,0 +1,162 @@
+package dirigera
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
+)
+
+const dialTimeout = 5 * time.Second
+
+var (
+	ErrConnectivity  = errors.New("hub not reachable")
+	ErrTLS           = errors.New("TLS fingerprint check failed")
+	ErrAuthorization = errors.New("access token rejected")
+	ErrConfiguration = errors.New("invalid hub configuration")
+)
+
+// DeviceInfo contains the information about a device as reported by the hub
+type DeviceInfo struct {
+	Hub          string                 `json:"hub,omitempty"` // name of the hub if several hubs are listed
+	ID           string                 `json:"id"`
+	Name         string                 `json:"name"`
+	Type         string                 `json:"type"`
+	DetailedType string                 `json:"deviceType"`
+	RoomID       string                 `json:"roomId"`
+	RoomName     string                 `json:"roomName"`
+	IsReachable  bool                   `json:"isReachable"`
+	LastSeen     time.Time              `json:"lastSeen"`
+	Attributes   map[string]interface{} `json:"attributes"`
+}
+
+// HubInfo contains the basic information about a hub returned by CheckHub
+type HubInfo struct {
+	Address         string `json:"address"`
+	Port            int    `json:"port"`
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	FirmwareVersion string `json:"firmwareVersion"`
+	TLSFingerprint  string `json:"tlsFingerprint"`
+}
+
+// ListDevices loads all devices from the hub, sorted by room and name.
+func ListDevices(cfg config.HubConfig) ([]DeviceInfo, error) {
+	cfg, err := resolve(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := connect(cfg).ListDevices()
+	if err != nil {
+		return nil, fmt.Errorf("error loading devices: %w", classifyStatus(err))
+	}
+	result := make([]DeviceInfo, 0, len(devices))
+	for _, device := range devices {
+		name, _ := device.Attributes["customName"].(string)
+		result = append(result, DeviceInfo{
+			ID:           device.ID,
+			Name:         name,
+			Type:         device.Type,
+			DetailedType: device.DetailedType,
+			RoomID:       device.Room.ID,
+			RoomName:     device.Room.Name,
+			IsReachable:  device.IsReachable,
+			LastSeen:     device.LastSeen,
+			Attributes:   device.Attributes,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].RoomName != result[j].RoomName {
+			return result[i].RoomName < result[j].RoomName
+		}
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// CheckHub verifies step by step the network connectivity, the TLS fingerprint and the access token.
+// The returned error wraps ErrConnectivity, ErrTLS or ErrAuthorization depending on the failed step.
+// With trust on first use, the fingerprint pinned in the state file is checked but not recorded.
+func CheckHub(cfg config.HubConfig) (*HubInfo, error) {
+	cfg, err := resolve(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := fetchFingerprint(cfg.Address, cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+	expected := config.NormalizeFingerprint(cfg.TLSFingerprint)
+	if fingerprint != expected {
+		return nil, &FingerprintMismatchError{Expected: expected, Actual: fingerprint}
+	}
+
+	hubStatus, err := connect(cfg).GetHubStatus()
+	if err != nil {
+		return nil, fmt.Errorf("error loading hub status: %w", classifyStatus(err))
+	}
+	hubName, _ := hubStatus.Attributes["customName"].(string)
+	firmwareVersion, _ := hubStatus.Attributes["firmwareVersion"].(string)
+	hubID, _ := normalizeID(hubStatus.ID)
+
+	return &HubInfo{
+		Address:         cfg.Address,
+		Port:            cfg.Port,
+		ID:              hubID,
+		Name:            hubName,
+		FirmwareVersion: firmwareVersion,
+		TLSFingerprint:  fingerprint,
+	}, nil
+}
+
+// connect creates a client for the hub without contacting it
+func connect(cfg config.HubConfig) client.Client {
+	authorization := client.NewAuthorization(cfg.Token, cfg.TLSFingerprint)
+	return client.Connect(cfg.Address, cfg.Port, &authorization)
+}
+
+// fetchFingerprint connects to the hub and returns the SHA-256 fingerprint of the certificate presented by the hub.
+func fetchFingerprint(address string, port int) (string, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	target := net.JoinHostPort(address, strconv.Itoa(port))
+	connection, err := tls.DialWithDialer(dialer, "tcp", target, &tls.Config{
+		InsecureSkipVerify: true, // the certificate of the hub is self-signed, it is verified by the fingerprint
+	})
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return "", fmt.Errorf("%w: %v", ErrConnectivity, err)
+		}
+		return "", fmt.Errorf("%w: %v", ErrTLS, err)
+	}
+	defer connection.Close()
+
+	certificates := connection.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return "", fmt.Errorf("%w: no certificate received from %s", ErrTLS, target)
+	}
+	hash := sha256.Sum256(certificates[0].Raw)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// classifyStatus wraps ErrAuthorization around errors caused by a rejected access token.
+// The hub client reports them only as status code in the error message.
+func classifyStatus(err error) error {
+	message := err.Error()
+	if strings.Contains(message, "status code 401") || strings.Contains(message, "status code 403") {
+		return fmt.Errorf("%w: %v", ErrAuthorization, err)
+	}
+	return err
+}
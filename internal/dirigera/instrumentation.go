@@ -0,0 +1,86 @@
+package dirigera
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+)
+
+// requestTimeout limits the requests to the hub, because the hub client has no timeouts
+const requestTimeout = 30 * time.Second
+
+// errStopped is returned for requests abandoned because the client was stopped
+var errStopped = errors.New("client stopped")
+
+// statusCodePattern extracts the HTTP status code, which the hub client reports only in the error message
+var statusCodePattern = regexp.MustCompile(`status code (\d{3})`)
+
+// instrumentedHub measures the requests of the exporter to the hub, all other methods are passed to the hub client
+type instrumentedHub struct {
+	client.Client
+	label   string // name of the hub configuration
+	metrics *exporterMetric
+	stopped <-chan struct{} // pending requests are abandoned when closed, nil for probes
+}
+
+func (h *instrumentedHub) ListDevices() ([]*client.Device, error) {
+	start := time.Now()
+	devices, err := request(h, func() ([]*client.Device, error) { return h.Client.ListDevices() })
+	h.observe("list_devices", start, err)
+	return devices, err
+}
+
+func (h *instrumentedHub) GetDevice(deviceID string) (*client.Device, error) {
+	start := time.Now()
+	device, err := request(h, func() (*client.Device, error) { return h.Client.GetDevice(deviceID) })
+	h.observe("get_device", start, err)
+	return device, err
+}
+
+func (h *instrumentedHub) GetHubStatus() (*client.Device, error) {
+	start := time.Now()
+	status, err := request(h, func() (*client.Device, error) { return h.Client.GetHubStatus() })
+	h.observe("get_hub_status", start, err)
+	return status, err
+}
+
+// request runs the request of the hub client, which is abandoned after requestTimeout or when the client is stopped
+func request[T any](h *instrumentedHub, do func() (T, error)) (T, error) {
+	type response struct {
+		value T
+		err   error
+	}
+	done := make(chan response, 1)
+	go func() {
+		value, err := do()
+		done <- response{value: value, err: err}
+	}()
+	var none T
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-h.stopped:
+		return none, errStopped
+	case <-time.After(requestTimeout):
+		return none, fmt.Errorf("%w: no response within %s", ErrConnectivity, requestTimeout)
+	}
+}
+
+func (h *instrumentedHub) observe(operation string, start time.Time, err error) {
+	h.metrics.observeRequest(h.label, operation, requestStatus(err), time.Since(start))
+}
+
+// requestStatus returns "ok" for successful requests, the HTTP status code if the hub answered with an error and
+// "error" if the hub could not be reached
+func requestStatus(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	if match := statusCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		return match[1]
+	}
+	return "error"
+}
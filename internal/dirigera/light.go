@@ -13,7 +13,7 @@ type lightMetric struct {
 	colorTemperatureMetric *prometheus.GaugeVec
 }
 
-func newLightMetric() dirigeraMetric {
+func newLightMetric(registerer prometheus.Registerer) dirigeraMetric {
 	metric := &lightMetric{
 		isOnMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: "ikea",
@@ -46,11 +46,11 @@ func newLightMetric() dirigeraMetric {
 			Help:      "Current color temperature of a light in kelvin (used only when in white mode)",
 		}, metricLabelNames),
 	}
-	prometheus.MustRegister(metric.isOnMetric)
-	prometheus.MustRegister(metric.levelMetric)
-	prometheus.MustRegister(metric.colorHueMetric)
-	prometheus.MustRegister(metric.colorSaturationMetric)
-	prometheus.MustRegister(metric.colorTemperatureMetric)
+	registerer.MustRegister(metric.isOnMetric)
+	registerer.MustRegister(metric.levelMetric)
+	registerer.MustRegister(metric.colorHueMetric)
+	registerer.MustRegister(metric.colorSaturationMetric)
+	registerer.MustRegister(metric.colorTemperatureMetric)
 
 	return metric
 }
@@ -76,3 +76,11 @@ func (m *lightMetric) update(device client.Device, labels prometheus.Labels) {
 		m.colorTemperatureMetric.With(labels).Set(colorTemperature)
 	}
 }
+
+func (m *lightMetric) remove(labels prometheus.Labels) {
+	m.isOnMetric.DeletePartialMatch(labels)
+	m.levelMetric.DeletePartialMatch(labels)
+	m.colorHueMetric.DeletePartialMatch(labels)
+	m.colorSaturationMetric.DeletePartialMatch(labels)
+	m.colorTemperatureMetric.DeletePartialMatch(labels)
+}
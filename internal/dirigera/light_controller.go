@@ -10,8 +10,10 @@ import (
 // the battery level
 type lightControllerMetric struct{}
 
-func newLightControllerMetric() dirigeraMetric {
+func newLightControllerMetric(registerer prometheus.Registerer) dirigeraMetric {
 	return &lightControllerMetric{}
 }
 
 func (m *lightControllerMetric) update(device client.Device, labels prometheus.Labels) {}
+
+func (m *lightControllerMetric) remove(labels prometheus.Labels) {}
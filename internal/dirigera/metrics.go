@@ -0,0 +1,57 @@
+package dirigera
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics contains the metrics of all device types registered at one registry.
+// They are shared by all hub clients, the series of the hubs are distinguished by the hub labels.
+type Metrics struct {
+	base       dirigeraMetric
+	info       *deviceInfoMetric
+	ota        *otaMetric
+	energy     *energyMetric
+	hub        *hubMetric
+	exporter   *exporterMetric
+	additional map[string]dirigeraMetric // key: device type
+}
+
+// NewMetrics creates the metrics and registers them at the given registerer
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	return &Metrics{
+		base:     newBaseDeviceMetric(registerer),
+		info:     newDeviceInfoMetric(registerer),
+		ota:      newOTAMetric(registerer),
+		energy:   newEnergyMetric(registerer),
+		hub:      newHubMetric(registerer),
+		exporter: newExporterMetric(registerer),
+		additional: map[string]dirigeraMetric{
+			"openCloseSensor":   newOpenCloseSensorMetric(registerer),
+			"environmentSensor": newEnvironmentSensorMetric(registerer),
+			"outlet":            newOutletMetric(registerer),
+			"lightController":   newLightControllerMetric(registerer),
+			"light":             newLightMetric(registerer),
+			"airPurifier":       newAirPurifierMetric(registerer),
+		},
+	}
+}
+
+// removeDevice deletes all series of the device from the metrics of all device types
+func (m *Metrics) removeDevice(hubID, deviceID string) {
+	m.remove(prometheus.Labels{"hub_id": hubID, "device_id": deviceID})
+}
+
+// removeHubDevices deletes all series of all devices of the hub, e.g. after the hub was renamed
+func (m *Metrics) removeHubDevices(hubID string) {
+	m.remove(prometheus.Labels{"hub_id": hubID})
+}
+
+func (m *Metrics) remove(labels prometheus.Labels) {
+	m.base.remove(labels)
+	m.info.remove(labels)
+	m.ota.remove(labels)
+	m.energy.remove(labels)
+	for _, metric := range m.additional {
+		metric.remove(labels)
+	}
+}
@@ -9,7 +9,7 @@ type openCloseSensorMetric struct {
 	openCloseMetric *prometheus.GaugeVec
 }
 
-func newOpenCloseSensorMetric() dirigeraMetric {
+func newOpenCloseSensorMetric(registerer prometheus.Registerer) dirigeraMetric {
 	metric := &openCloseSensorMetric{
 		openCloseMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: "ikea",
@@ -18,7 +18,7 @@ func newOpenCloseSensorMetric() dirigeraMetric {
 			Help:      "Current status of an open-close sensor (0 = closed, 1 = open)",
 		}, metricLabelNames),
 	}
-	prometheus.MustRegister(metric.openCloseMetric)
+	registerer.MustRegister(metric.openCloseMetric)
 
 	return metric
 }
@@ -33,3 +33,7 @@ func (m *openCloseSensorMetric) update(device client.Device, labels prometheus.L
 	}
 	m.openCloseMetric.With(labels).Set(value)
 }
+
+func (m *openCloseSensorMetric) remove(labels prometheus.Labels) {
+	m.openCloseMetric.DeletePartialMatch(labels)
+}
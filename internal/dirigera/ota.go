@@ -0,0 +1,156 @@
+package dirigera
+
+import (
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+)
+
+// otaMetric contains the state of the firmware updates (over the air) of all devices
+type otaMetric struct {
+	updateAvailableMetric *prometheus.GaugeVec
+	stateMetric           *prometheus.GaugeVec
+	progressMetric        *prometheus.GaugeVec
+	policyMetric          *prometheus.GaugeVec
+	updatesMetric         *prometheus.CounterVec
+	firmwareChangedMetric *prometheus.GaugeVec
+}
+
+func newOTAMetric(registerer prometheus.Registerer) *otaMetric {
+	metric := &otaMetric{
+		updateAvailableMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "device",
+			Name:      "ota_update_available",
+			Help:      "Firmware update available for a device (0 = up to date, 1 = update available)",
+		}, metricLabelNames),
+		stateMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "device",
+			Name:      "ota_state",
+			Help:      "Current state of the firmware update of a device, the value is always 1",
+		}, append(metricLabelNames, "state")),
+		progressMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "device",
+			Name:      "ota_progress",
+			Help:      "Progress of the current firmware update of a device (percent)",
+		}, metricLabelNames),
+		policyMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "device",
+			Name:      "ota_policy",
+			Help:      "Policy for firmware updates of a device, the value is always 1",
+		}, append(metricLabelNames, "policy")),
+		updatesMetric: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ikea",
+			Subsystem: "device",
+			Name:      "firmware_updates_total",
+			Help:      "Number of completed firmware updates of a device, detected by a changed firmware version",
+		}, metricLabelNames),
+		firmwareChangedMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ikea",
+			Subsystem: "device",
+			Name:      "firmware_changed_timestamp_seconds",
+			Help:      "Last time the firmware version of a device changed or was first seen by the exporter (Unix timestamp in seconds)",
+		}, metricLabelNames),
+	}
+	registerer.MustRegister(metric.updateAvailableMetric)
+	registerer.MustRegister(metric.stateMetric)
+	registerer.MustRegister(metric.progressMetric)
+	registerer.MustRegister(metric.policyMetric)
+	registerer.MustRegister(metric.updatesMetric)
+	registerer.MustRegister(metric.firmwareChangedMetric)
+
+	return metric
+}
+
+// update sets the OTA metrics from the state of a major device, events contain only the changed attributes
+func (m *otaMetric) update(device client.Device, labels prometheus.Labels) {
+	deviceLabels := prometheus.Labels{"hub_id": labels["hub_id"], "device_id": labels["device_id"]}
+	if otaStatus, hasOtaStatus := device.Attributes["otaStatus"].(string); hasOtaStatus {
+		var value float64 = 0
+		if otaStatus == "updateAvailable" {
+			value = 1
+		}
+		m.updateAvailableMetric.With(labels).Set(value)
+	}
+	if otaState, hasOtaState := device.Attributes["otaState"].(string); hasOtaState {
+		m.stateMetric.DeletePartialMatch(deviceLabels)
+		m.stateMetric.With(withLabel(labels, "state", otaState)).Set(1)
+	}
+	if progress, hasProgress := device.Attributes["otaProgress"].(float64); hasProgress {
+		m.progressMetric.With(labels).Set(progress)
+	}
+	if policy, hasPolicy := device.Attributes["otaPolicy"].(string); hasPolicy {
+		m.policyMetric.DeletePartialMatch(deviceLabels)
+		m.policyMetric.With(withLabel(labels, "policy", policy)).Set(1)
+	}
+}
+
+// updateFirmware sets the time of the last firmware change and counts the update if the version changed
+func (m *otaMetric) updateFirmware(firmware firmwareState, updated bool, labels prometheus.Labels) {
+	counter := m.updatesMetric.With(labels) // creates the series with 0 for devices not updated yet
+	if updated {
+		counter.Inc()
+	}
+	m.firmwareChangedMetric.With(labels).Set(float64(firmware.Changed.Unix()))
+}
+
+func (m *otaMetric) remove(labels prometheus.Labels) {
+	m.updateAvailableMetric.DeletePartialMatch(labels)
+	m.stateMetric.DeletePartialMatch(labels)
+	m.progressMetric.DeletePartialMatch(labels)
+	m.policyMetric.DeletePartialMatch(labels)
+	m.updatesMetric.DeletePartialMatch(labels)
+	m.firmwareChangedMetric.DeletePartialMatch(labels)
+}
+
+// withLabel returns a copy of the labels with the additional label
+func withLabel(labels prometheus.Labels, name, value string) prometheus.Labels {
+	result := maps.Clone(labels)
+	result[name] = value
+	return result
+}
+
+// trackFirmware compares the firmware version of the device with the known version, which is read from the state
+// file of the hub if configured. Returns the firmware state and a flag indicating if the version changed.
+// The registry must be locked by updateMutex.
+func (d *dirigeraClient) trackFirmware(device RegisteredDevice) (firmwareState, bool) {
+	d.loadState()
+	known, isKnown := d.firmware[device.ID]
+	version := device.Details.FirmwareVersion
+	if version == "" || (isKnown && known.Version == version) {
+		return known, false
+	}
+
+	current := firmwareState{Version: version, Changed: time.Now()}
+	d.firmware[device.ID] = current
+	d.stateChanged = true
+	if isKnown {
+		fmt.Printf("Firmware of device %s updated from %s to %s\n", device.ID, known.Version, version)
+	}
+	return current, isKnown
+}
+
+// updateOTA updates the OTA metrics from the state of the major device - after relabeling from its known state,
+// because the event contains only the changed values - and tracks the firmware version.
+// The registry must be locked by updateMutex.
+func (d *dirigeraClient) updateOTA(registered RegisteredDevice, device client.Device, relabeled bool, labels prometheus.Labels) {
+	if relabeled {
+		for id, state := range registered.States {
+			if _, isRoot := normalizeID(id); isRoot {
+				device = state
+			}
+		}
+	}
+	if _, isRoot := normalizeID(device.ID); isRoot {
+		d.metrics.ota.update(device, labels)
+	}
+	if firmware, updated := d.trackFirmware(registered); !firmware.Changed.IsZero() {
+		d.metrics.ota.updateFirmware(firmware, updated, labels)
+	}
+}
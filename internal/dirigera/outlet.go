@@ -12,7 +12,7 @@ type outletMetric struct {
 	currentActivePowerMetric *prometheus.GaugeVec
 }
 
-func newOutletMetric() dirigeraMetric {
+func newOutletMetric(registerer prometheus.Registerer) dirigeraMetric {
 	metric := &outletMetric{
 		isOnMetric: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: "ikea",
@@ -39,10 +39,10 @@ func newOutletMetric() dirigeraMetric {
 			Help:      "Power currently consumed at an outlet - consumers only (watts)",
 		}, metricLabelNames),
 	}
-	prometheus.MustRegister(metric.isOnMetric)
-	prometheus.MustRegister(metric.currentVoltageMetric)
-	prometheus.MustRegister(metric.currentAmpsMetric)
-	prometheus.MustRegister(metric.currentActivePowerMetric)
+	registerer.MustRegister(metric.isOnMetric)
+	registerer.MustRegister(metric.currentVoltageMetric)
+	registerer.MustRegister(metric.currentAmpsMetric)
+	registerer.MustRegister(metric.currentActivePowerMetric)
 
 	return metric
 }
@@ -65,3 +65,10 @@ func (m *outletMetric) update(device client.Device, labels prometheus.Labels) {
 		m.currentActivePowerMetric.With(labels).Set(power)
 	}
 }
+
+func (m *outletMetric) remove(labels prometheus.Labels) {
+	m.isOnMetric.DeletePartialMatch(labels)
+	m.currentVoltageMetric.DeletePartialMatch(labels)
+	m.currentAmpsMetric.DeletePartialMatch(labels)
+	m.currentActivePowerMetric.DeletePartialMatch(labels)
+}
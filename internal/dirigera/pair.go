@@ -0,0 +1,30 @@
+package dirigera
+
+import (
+	"fmt"
+
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+)
+
+// Pairing contains the credentials obtained by pairing with a hub
+type Pairing struct {
+	AccessToken    string
+	TLSFingerprint string
+}
+
+// Pair performs the authorization handshake with the hub. The user has to press the action button on the hub
+// after waitForButton was called. While the hub waits for the button to be pressed, waiting is called repeatedly.
+// The fingerprint of the certificate presented by the hub is captured during the handshake.
+func Pair(address string, port int, clientName string, waitForButton, waiting func()) (*Pairing, error) {
+	authorization, err := client.Authorize(address, port, clientName, waitForButton, waiting)
+	if err != nil {
+		return nil, fmt.Errorf("error pairing with hub %s:%d: %w", address, port, err)
+	}
+	if authorization.AccessToken == "" {
+		return nil, fmt.Errorf("hub %s:%d returned no access token", address, port)
+	}
+	return &Pairing{
+		AccessToken:    authorization.AccessToken,
+		TLSFingerprint: authorization.TLSFingerprint,
+	}, nil
+}
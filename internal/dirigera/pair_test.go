@@ -0,0 +1,86 @@
+package dirigera
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// newFakeHub starts a hub serving the authorization handshake, the token is returned after the button was pressed
+// on the first poll
+func newFakeHub(t *testing.T, token string) (*httptest.Server, string, int) {
+	t.Helper()
+	var polls atomic.Int32
+	hub := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/oauth/authorize":
+			if r.URL.Query().Get("code_challenge") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"code":"auth-code"}`)
+		case "/v1/oauth/token":
+			if err := r.ParseForm(); err != nil || r.PostForm.Get("code") != "auth-code" || r.PostForm.Get("code_verifier") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if polls.Add(1) == 1 {
+				w.WriteHeader(http.StatusForbidden) // button not pressed yet
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"access_token":%q}`, token)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(hub.Close)
+
+	host, portValue, err := net.SplitHostPort(hub.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hub, host, port
+}
+
+func TestPair(t *testing.T) {
+	hub, address, port := newFakeHub(t, "access-token")
+	hash := sha256.Sum256(hub.Certificate().Raw)
+	expectedFingerprint := hex.EncodeToString(hash[:])
+
+	buttonRequested, waited := 0, 0
+	pairing, err := Pair(address, port, "exporter-test", func() { buttonRequested++ }, func() { waited++ })
+	if err != nil {
+		t.Fatalf("Pair() failed: %v", err)
+	}
+	if pairing.AccessToken != "access-token" {
+		t.Errorf("AccessToken = %q, want %q", pairing.AccessToken, "access-token")
+	}
+	if pairing.TLSFingerprint != expectedFingerprint {
+		t.Errorf("TLSFingerprint = %q, want %q", pairing.TLSFingerprint, expectedFingerprint)
+	}
+	if buttonRequested != 1 {
+		t.Errorf("waitForButton called %d times, want 1", buttonRequested)
+	}
+	if waited != 1 {
+		t.Errorf("waiting called %d times, want 1", waited)
+	}
+}
+
+func TestPairUnreachable(t *testing.T) {
+	hub, address, port := newFakeHub(t, "access-token")
+	hub.Close()
+
+	if _, err := Pair(address, port, "exporter-test", func() {}, func() {}); err == nil {
+		t.Fatal("Pair() succeeded for a stopped hub")
+	}
+}
@@ -0,0 +1,94 @@
+package dirigera
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
+)
+
+// maxProbesPerTarget limits the probes running for one target. The hub client has no timeout for requests, so a probe
+// abandoned after the timeout keeps running until the hub answers.
+const maxProbesPerTarget = 2
+
+var (
+	probesMutex   sync.Mutex
+	probesRunning = make(map[string]int) // key: address and port of the target
+)
+
+// Probe connects to the hub given by the target with the settings of the module, loads all devices and registers
+// their metrics at the registerer. The target is the address of the hub, optionally with a port overriding the
+// port of the module.
+func Probe(target string, module config.ModuleConfig, registerer prometheus.Registerer) error {
+	address, port := target, module.Port
+	if host, portValue, err := net.SplitHostPort(target); err == nil {
+		address = host
+		port, err = strconv.Atoi(portValue)
+		if err != nil {
+			return fmt.Errorf("invalid port in target %q", target)
+		}
+	}
+	cfg, err := module.HubConfig(address, port).ResolveSecrets()
+	if err != nil {
+		return err
+	}
+	key := net.JoinHostPort(cfg.Address, strconv.Itoa(cfg.Port))
+	if !startProbe(key) {
+		return fmt.Errorf("probe of %s rejected, %d probes are still running", target, maxProbesPerTarget)
+	}
+
+	probeClient := &dirigeraClient{
+		label:        target,
+		cfg:          cfg,
+		registry:     newRegistry(),
+		metrics:      NewMetrics(registerer),
+		powerSamples: make(map[string]powerSample),
+		estimated:    make(map[string]float64),
+	}
+	hub := &instrumentedHub{Client: connect(cfg), label: target, metrics: probeClient.metrics.exporter}
+	probeClient.setHub(hub, cfg)
+
+	// The hub client has no timeout for requests, so the probe is abandoned after the timeout of the module
+	loaded := make(chan error, 1)
+	go func() {
+		defer finishProbe(key)
+		// Fails with the dial timeout if the hub is not reachable, before the requests without timeout are sent
+		if _, err := fetchFingerprint(cfg.Address, cfg.Port); err != nil {
+			loaded <- err
+			return
+		}
+		loaded <- probeClient.load()
+	}()
+	select {
+	case err := <-loaded:
+		return err
+	case <-time.After(module.Timeout):
+		return fmt.Errorf("probe of %s timed out after %s", target, module.Timeout)
+	}
+}
+
+// startProbe counts the probe for the target, returns false if too many probes are running
+func startProbe(key string) bool {
+	probesMutex.Lock()
+	defer probesMutex.Unlock()
+
+	if probesRunning[key] >= maxProbesPerTarget {
+		return false
+	}
+	probesRunning[key]++
+	return true
+}
+
+func finishProbe(key string) {
+	probesMutex.Lock()
+	defer probesMutex.Unlock()
+
+	probesRunning[key]--
+	if probesRunning[key] == 0 {
+		delete(probesRunning, key)
+	}
+}
@@ -0,0 +1,271 @@
+package dirigera
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"sync"
+
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+)
+
+// ChangeType is the kind of change of a device in the registry
+type ChangeType int
+
+const (
+	DeviceAdded ChangeType = iota
+	DeviceUpdated
+	DeviceRemoved
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case DeviceAdded:
+		return "added"
+	case DeviceUpdated:
+		return "updated"
+	case DeviceRemoved:
+		return "removed"
+	default:
+		return fmt.Sprintf("ChangeType(%d)", int(t))
+	}
+}
+
+// RegisteredDevice is a device known to the registry. Devices attached to a major device, e.g. the buttons of a
+// remote control, are registered with the major device and share its name and room.
+type RegisteredDevice struct {
+	ID       string // ID without suffix
+	Name     string
+	Type     string // detailed type of the major device
+	RoomID   string
+	RoomName string
+	Details  DeviceDetails // details of the major device
+
+	// States contains the last known state of the device and the attached devices, key: device ID.
+	// The states are shared with the registry and must not be modified.
+	States map[string]client.Device
+}
+
+// DeviceDetails contains the hardware and firmware information of a major device reported by the hub
+type DeviceDetails struct {
+	Model           string
+	Manufacturer    string
+	FirmwareVersion string
+	HardwareVersion string
+	SerialNumber    string
+	ProductCode     string
+}
+
+// merge returns the details with the values contained in the attributes of the device, events contain only
+// the changed attributes
+func (d DeviceDetails) merge(device client.Device) DeviceDetails {
+	for name, target := range map[string]*string{
+		"model":           &d.Model,
+		"manufacturer":    &d.Manufacturer,
+		"firmwareVersion": &d.FirmwareVersion,
+		"hardwareVersion": &d.HardwareVersion,
+		"serialNumber":    &d.SerialNumber,
+		"productCode":     &d.ProductCode,
+	} {
+		if value, hasValue := device.Attributes[name].(string); hasValue {
+			*target = value
+		}
+	}
+	return d
+}
+
+// DeviceChange is sent to the subscribers of the registry
+type DeviceChange struct {
+	Type     ChangeType
+	Device   RegisteredDevice
+	Previous *RegisteredDevice // device before the change, nil if added
+}
+
+// Registry contains the devices of a hub and notifies subscribers about changes, it can be used concurrently
+type Registry struct {
+	mutex       sync.RWMutex
+	devices     map[string]*RegisteredDevice // key: ID without suffix
+	subscribers map[chan DeviceChange]struct{}
+}
+
+func newRegistry() *Registry {
+	return &Registry{
+		devices:     make(map[string]*RegisteredDevice),
+		subscribers: make(map[chan DeviceChange]struct{}),
+	}
+}
+
+// Snapshot returns a consistent copy of all devices sorted by ID
+func (r *Registry) Snapshot() []RegisteredDevice {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	devices := make([]RegisteredDevice, 0, len(r.devices))
+	for _, device := range r.devices {
+		devices = append(devices, device.copy())
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices
+}
+
+// Get returns a copy of the device with the ID without suffix
+func (r *Registry) Get(id string) (RegisteredDevice, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	device, isRegistered := r.devices[id]
+	if !isRegistered {
+		return RegisteredDevice{}, false
+	}
+	return device.copy(), true
+}
+
+// size returns the number of registered major devices
+func (r *Registry) size() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return len(r.devices)
+}
+
+// Subscribe returns a channel receiving all changes of the registry and a function for cancelling the subscription.
+// Changes are dropped while the buffer of the channel is full, so subscribers should read the current state with
+// Snapshot after subscribing and when they fall behind.
+func (r *Registry) Subscribe(buffer int) (<-chan DeviceChange, func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	changes := make(chan DeviceChange, buffer)
+	r.subscribers[changes] = struct{}{}
+	var cancelOnce sync.Once
+	return changes, func() {
+		cancelOnce.Do(func() {
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+
+			delete(r.subscribers, changes)
+			close(changes)
+		})
+	}
+}
+
+// register adds the major device or updates its name, type and room.
+// Returns the registered device and a flag indicating if the labels of a device registered before changed.
+func (r *Registry) register(rootDevice client.Device) (RegisteredDevice, bool, error) {
+	id, _ := normalizeID(rootDevice.ID)
+	name, hasName := rootDevice.Attributes["customName"].(string)
+	if !hasName {
+		return RegisteredDevice{}, false, fmt.Errorf("device %s has no customName", rootDevice.ID)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	device, isRegistered := r.devices[id]
+	if !isRegistered {
+		device = &RegisteredDevice{ID: id, States: make(map[string]client.Device)}
+		r.devices[id] = device
+	}
+	previous := device.copy()
+	device.Name = name
+	device.Type = rootDevice.DetailedType
+	device.RoomID = rootDevice.Room.ID
+	device.RoomName = rootDevice.Room.Name
+	device.Details = device.Details.merge(rootDevice)
+
+	switch {
+	case !isRegistered:
+		r.notify(DeviceChange{Type: DeviceAdded, Device: device.copy()})
+	case previous.labels() != device.labels():
+		r.notify(DeviceChange{Type: DeviceUpdated, Device: device.copy(), Previous: &previous})
+		return device.copy(), true, nil
+	case previous.Details != device.Details:
+		r.notify(DeviceChange{Type: DeviceUpdated, Device: device.copy(), Previous: &previous})
+	}
+	return device.copy(), false, nil
+}
+
+// updateState sets the state of a device of a registered major device, with merge the attributes are merged into
+// the known state. The details are updated from the state of the major device.
+// Returns the registered device, false if the major device is not registered.
+func (r *Registry) updateState(state client.Device, merge bool) (RegisteredDevice, bool) {
+	id, _ := normalizeID(state.ID)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	device, isRegistered := r.devices[id]
+	if !isRegistered {
+		return RegisteredDevice{}, false
+	}
+	previous := device.copy()
+	if merge {
+		state = mergeDevice(device.States[state.ID], state)
+	}
+	device.States[state.ID] = state
+	if _, isRoot := normalizeID(state.ID); isRoot {
+		device.Details = device.Details.merge(state)
+	}
+	r.notify(DeviceChange{Type: DeviceUpdated, Device: device.copy(), Previous: &previous})
+	return device.copy(), true
+}
+
+// removeState removes the state of a device. The major device is removed with the last state.
+// Returns true if the major device was removed.
+func (r *Registry) removeState(deviceID string) bool {
+	id, _ := normalizeID(deviceID)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	device, isRegistered := r.devices[id]
+	if !isRegistered {
+		return false
+	}
+	previous := device.copy()
+	delete(device.States, deviceID)
+	if len(device.States) > 0 {
+		if len(device.States) < len(previous.States) {
+			r.notify(DeviceChange{Type: DeviceUpdated, Device: device.copy(), Previous: &previous})
+		}
+		return false
+	}
+	delete(r.devices, id)
+	r.notify(DeviceChange{Type: DeviceRemoved, Device: previous, Previous: &previous})
+	return true
+}
+
+// remove removes the major device with all attached devices
+func (r *Registry) remove(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	device, isRegistered := r.devices[id]
+	if !isRegistered {
+		return
+	}
+	delete(r.devices, id)
+	previous := device.copy()
+	r.notify(DeviceChange{Type: DeviceRemoved, Device: previous, Previous: &previous})
+}
+
+// notify sends the change to all subscribers without waiting, the registry must be locked
+func (r *Registry) notify(change DeviceChange) {
+	for subscriber := range r.subscribers {
+		select {
+		case subscriber <- change:
+		default: // subscriber falls behind
+		}
+	}
+}
+
+func (d *RegisteredDevice) copy() RegisteredDevice {
+	device := *d
+	device.States = maps.Clone(d.States)
+	return device
+}
+
+// labels returns the values used as labels of the metrics
+func (d *RegisteredDevice) labels() [5]string {
+	return [5]string{d.ID, d.Name, d.Type, d.RoomID, d.RoomName}
+}
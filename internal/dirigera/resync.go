@@ -0,0 +1,158 @@
+package dirigera
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+)
+
+// syncResult contains the differences between the devices listed by the hub and the devices known before
+type syncResult struct {
+	drifted int // values changed without event
+	added   int // new devices
+	changed int // renamed or moved devices
+	removed int // devices no longer present
+}
+
+// resync loads the hub status and all devices and corrects the values missed by the event loop
+func (d *dirigeraClient) resync() {
+	if err := d.loadHub(); err != nil {
+		fmt.Printf("Warning: Resync of hub %s failed: %v\n", d.label, err)
+		return
+	}
+	result, err := d.synchronize()
+	if err != nil {
+		fmt.Printf("Warning: Resync of hub %s failed: %v\n", d.label, err)
+		return
+	}
+	d.metrics.exporter.setDrift(d.label, result.drifted)
+	if result != (syncResult{}) {
+		fmt.Printf("Resync of hub %s: %d values drifted, %d devices added, %d changed, %d removed\n",
+			d.label, result.drifted, result.added, result.changed, result.removed)
+	}
+}
+
+// synchronize lists all devices, reconciles the registry with them and updates the metrics
+func (d *dirigeraClient) synchronize() (syncResult, error) {
+	devices, err := d.currentHub().ListDevices()
+	if err != nil {
+		return syncResult{}, fmt.Errorf("error loading devices: %w", err)
+	}
+
+	var result syncResult
+	d.updateMutex.Lock()
+	listed := make(map[string]bool)
+	listedIDs := make(map[string]bool) // IDs without suffix
+	paired := make(map[string]int)     // key: device type
+	for _, device := range devices {
+		if device.DetailedType == "gateway" {
+			continue
+		}
+		deviceID, isRoot := normalizeID(device.ID)
+		if isRoot {
+			paired[device.DetailedType]++
+		}
+		listed[device.ID] = true
+		listedIDs[deviceID] = true
+		registered, _ := d.registry.Get(deviceID)
+		if known, isKnown := registered.States[device.ID]; isKnown {
+			result.drifted += drift(known, *device)
+		} else {
+			result.added++
+		}
+
+		// The list contains the complete details of major devices, so they need not to be read again
+		if !isRoot || device.Room.Name == "" {
+			continue
+		}
+		if _, relabeled, err := d.registry.register(*device); err == nil && relabeled {
+			// Delete the series with the old labels, they are emitted again with the new labels below
+			d.metrics.removeDevice(d.hubID, deviceID)
+			result.changed++
+		}
+	}
+	for _, registered := range d.registry.Snapshot() {
+		// Devices attached to a major device share its labels, so the series are removed with the last of them
+		if !listedIDs[registered.ID] {
+			d.registry.remove(registered.ID)
+			d.metrics.removeDevice(d.hubID, registered.ID)
+			d.forgetDevice(registered.ID)
+			result.removed++
+			continue
+		}
+		for id := range registered.States {
+			if !listed[id] {
+				d.registry.removeState(id)
+			}
+		}
+	}
+	d.metrics.exporter.setCacheSize(d.label, d.registry.size())
+	d.metrics.hub.setDevices(paired, d.hubLabels())
+	d.updateMutex.Unlock()
+
+	for _, device := range devices {
+		d.updateMetric(*device, nil)
+	}
+	d.updateMutex.Lock()
+	d.saveState(true)
+	d.updateMutex.Unlock()
+	return result, nil
+}
+
+// drift returns the number of values of the current device state differing from the known state
+func drift(known, current client.Device) int {
+	drifted := 0
+	if known.IsReachable != current.IsReachable {
+		drifted++
+	}
+	for name, value := range current.Attributes {
+		if !reflect.DeepEqual(known.Attributes[name], value) {
+			drifted++
+		}
+	}
+	return drifted
+}
+
+// mergeDevice merges the device state received with an event into the known state,
+// because events contain only the changed attributes
+func mergeDevice(known, changed client.Device) client.Device {
+	attributes := maps.Clone(known.Attributes)
+	if attributes == nil {
+		attributes = make(map[string]interface{})
+	}
+	maps.Copy(attributes, changed.Attributes)
+	changed.Attributes = attributes
+	return changed
+}
+
+func (d *dirigeraClient) removeDeviceFromEvent(event client.Event) {
+	fmt.Printf("Device %s removed from hub %s\n", event.Device.ID, d.label)
+
+	d.updateMutex.Lock()
+	defer d.updateMutex.Unlock()
+	// Devices attached to a major device share its labels, so the series are removed with the last of them
+	if d.registry.removeState(event.Device.ID) {
+		deviceID, _ := normalizeID(event.Device.ID)
+		d.metrics.removeDevice(d.hubID, deviceID)
+		d.forgetDevice(deviceID)
+		d.metrics.exporter.setCacheSize(d.label, d.registry.size())
+		d.saveState(false)
+	}
+}
+
+// forgetDevice deletes the firmware version, the energy offset and the estimated energy of a removed device.
+// The registry must be locked by updateMutex.
+func (d *dirigeraClient) forgetDevice(deviceID string) {
+	d.loadState()
+	_, hasFirmware := d.firmware[deviceID]
+	_, hasEnergy := d.energy[deviceID]
+	if hasFirmware || hasEnergy {
+		delete(d.firmware, deviceID)
+		delete(d.energy, deviceID)
+		d.stateChanged = true
+	}
+	delete(d.estimated, deviceID)
+	delete(d.powerSamples, deviceID)
+}
@@ -0,0 +1,39 @@
+package dirigera
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+)
+
+func TestRemoveDeviceFromEvent(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	d := newTestClient(stateFile)
+	start := time.Now()
+	for _, at := range []time.Time{start, start.Add(time.Hour)} {
+		state := outlet(true, map[string]interface{}{"currentActivePower": 100.0})
+		registered := registerOutlet(t, d, state)
+		d.updateEstimatedEnergy(registered, state, at, false, d.createLabels(registered))
+	}
+	d.trackEnergy("outlet", 5, energyReset{})
+	d.firmware["outlet"] = firmwareState{Version: "1.0", Changed: start}
+	d.saveState(true)
+
+	d.removeDeviceFromEvent(client.Event{Device: client.Device{ID: "outlet_1"}})
+
+	if _, isRegistered := d.registry.Get("outlet"); isRegistered {
+		t.Error("device still registered after removal")
+	}
+	if len(d.firmware) != 0 || len(d.energy) != 0 || len(d.estimated) != 0 || len(d.powerSamples) != 0 {
+		t.Errorf("state of removed device kept: firmware %v, energy %v, estimated %v, power samples %v",
+			d.firmware, d.energy, d.estimated, d.powerSamples)
+	}
+	// The state file must not contain the device anymore, otherwise it is restored after a restart
+	restarted := newTestClient(stateFile)
+	restarted.loadState()
+	if len(restarted.firmware) != 0 || len(restarted.energy) != 0 {
+		t.Errorf("state file contains removed device: firmware %v, energy %v", restarted.firmware, restarted.energy)
+	}
+}
@@ -0,0 +1,142 @@
+package dirigera
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// hubState contains the data persisted between restarts of the exporter
+type hubState struct {
+	TLSFingerprint string                   `json:"tls_fingerprint,omitempty"`
+	Firmware       map[string]firmwareState `json:"firmware,omitempty"` // key: device ID without suffix
+	Energy         map[string]energyState   `json:"energy,omitempty"`   // key: device ID without suffix
+}
+
+// firmwareState contains the firmware version of a device and the time of the last change, which is the time the
+// version was first seen by the exporter
+type firmwareState struct {
+	Version string    `json:"version"`
+	Changed time.Time `json:"changed"`
+}
+
+// stateStore persists the hubState as JSON file
+type stateStore struct {
+	path  string
+	mutex sync.Mutex
+	state hubState
+}
+
+// loadStateStore reads the state from the file, a missing file results in an empty state
+func loadStateStore(path string) (*stateStore, error) {
+	store := &stateStore{path: path}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *stateStore) load() error {
+	content, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading state file: %w", err)
+	}
+	var state hubState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return fmt.Errorf("error parsing state file %s: %w", s.path, err)
+	}
+	s.state = state
+	return nil
+}
+
+// update changes the state with the given function and writes it to the file. The file is read again before,
+// because several stores might be used for the same file, e.g. for pinning the fingerprint and for the firmware.
+func (s *stateStore) update(change func(state *hubState)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	change(&s.state)
+	content, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling state: %w", err)
+	}
+	// Write to a temporary file first to never leave a partially written state file
+	tempFile := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err := os.WriteFile(tempFile, content, 0600); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := os.Rename(tempFile, s.path); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	return nil
+}
+
+// read returns a copy of the current state
+func (s *stateStore) read() hubState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.state
+}
+
+func (d *dirigeraClient) stateFile() string {
+	d.hubMutex.Lock()
+	defer d.hubMutex.Unlock()
+
+	return d.cfg.StateFile
+}
+
+// loadState reads the firmware versions and the energy offsets from the state file of the hub if configured,
+// only once after the start. The registry must be locked by updateMutex.
+func (d *dirigeraClient) loadState() {
+	if d.firmware != nil {
+		return
+	}
+	d.firmware = make(map[string]firmwareState)
+	d.energy = make(map[string]energyState)
+	path := d.stateFile()
+	if path == "" {
+		return
+	}
+	store, err := loadStateStore(path)
+	if err != nil {
+		fmt.Printf("Warning: Could not read device state: %v\n", err)
+		return
+	}
+	state := store.read()
+	maps.Copy(d.firmware, state.Firmware)
+	maps.Copy(d.energy, state.Energy)
+}
+
+// saveState writes the firmware versions and the energy offsets to the state file of the hub if configured.
+// Without force, the state is only written after important changes - the last energy readings alone are written
+// periodically by the resync. The registry must be locked by updateMutex.
+func (d *dirigeraClient) saveState(force bool) {
+	path := d.stateFile()
+	if path == "" || d.firmware == nil || (!d.stateChanged && !force) {
+		return
+	}
+	store, err := loadStateStore(path)
+	if err == nil {
+		err = store.update(func(state *hubState) {
+			state.Firmware = maps.Clone(d.firmware)
+			state.Energy = maps.Clone(d.energy)
+		})
+	}
+	if err != nil {
+		fmt.Printf("Warning: Could not save device state: %v\n", err)
+		return
+	}
+	d.stateChanged = false
+}
@@ -0,0 +1,234 @@
+package dirigera
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+)
+
+const (
+	// initialBackoff is the delay before the first reconnect attempt, it is doubled with every failed attempt
+	// up to maxBackoff
+	initialBackoff = 1 * time.Second
+	maxBackoff     = 5 * time.Minute
+)
+
+// listen connects to the hub and supervises the event loop. When connecting or the event loop fails, the hub is
+// reconnected with exponential backoff and all devices are synchronized again. Changed connection settings are
+// applied by reconnecting immediately.
+func (d *dirigeraClient) listen() error {
+	for failures := 0; ; {
+		if failures > 0 {
+			delay := backoff(failures)
+			fmt.Printf("Reconnecting to hub %s in %s\n", d.label, delay.Round(time.Millisecond))
+			select {
+			case <-d.stopped:
+				return nil
+			case <-d.reconfigured:
+				// Changed settings, e.g. a new token after it was rejected, are tried immediately
+				failures = 0
+			case <-time.After(delay):
+			}
+			d.metrics.exporter.countReconnectAttempt(d.label)
+		}
+		if err := d.reconnect(); err != nil {
+			if errors.Is(err, errStopped) {
+				return nil
+			}
+			fmt.Printf("Error connecting to hub %s: %v\n", d.label, err)
+			d.setConnectionError(err)
+			failures++
+			continue
+		}
+
+		connected, err := d.runEventLoop()
+		if err == nil || errors.Is(err, errStopped) {
+			return nil // stopped
+		}
+		fmt.Printf("Error in event loop of hub %s: %v\n", d.label, err)
+		d.setConnectionError(err)
+		d.metrics.exporter.setConnected(d.label, false)
+		if connected {
+			failures = 1 // start again with the initial backoff after a working connection
+		} else {
+			failures++
+		}
+	}
+}
+
+// runEventLoop runs the event loop until it fails or the client is stopped. The returned error is nil if stopped.
+// Also returns a flag indicating if the connection to the hub was established in the meantime.
+func (d *dirigeraClient) runEventLoop() (bool, error) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	resyncTicker := time.NewTicker(d.resyncInterval())
+	defer resyncTicker.Stop()
+
+	connected := false
+	keepAliveDone := make(chan error, 1)
+	keepAliveRunning := false
+	var lastKeepAlive time.Time
+	var silence *EventSilenceError
+	for {
+		hub := d.currentHub()
+		drain(d.loopConnected)
+		drain(d.loopFailed)
+		finished := make(chan error, 1)
+		go func() {
+			finished <- hub.ListenForEvents()
+		}()
+
+		for reconnected := false; !reconnected; {
+			select {
+			case err := <-finished:
+				// The event loop of the hub client only ends by itself if it could not be started
+				if err == nil {
+					err = errors.New("event loop ended unexpectedly")
+				}
+				return connected, err
+			case <-d.stopped:
+				stopEventLoop(hub, finished)
+				return connected, nil
+			case <-d.loopConnected:
+				connected = true
+				d.recordConnect()
+				d.setConnectionError(nil)
+				d.metrics.exporter.setConnected(d.label, true)
+				continue
+			case <-d.loopFailed:
+				err := hub.GetEventLoopState()
+				stopEventLoop(hub, finished)
+				if err == nil {
+					err = errors.New("event loop failed")
+				}
+				return connected, err
+			case <-resyncTicker.C:
+				d.resync()
+				continue
+			case err := <-keepAliveDone:
+				keepAliveRunning = false
+				if err != nil {
+					stopEventLoop(hub, finished)
+					return connected, fmt.Errorf("keep-alive probe failed: %w", err)
+				}
+				continue
+			case <-d.reconfigured:
+				resyncTicker.Reset(d.resyncInterval())
+			case <-ticker.C:
+				silence = d.reportSilence(silence != nil)
+				if silence != nil && d.keepAliveEnabled() {
+					// The keep-alive probe still succeeds while the open event connection delivers nothing,
+					// so the event connection is established again
+					stopEventLoop(hub, finished)
+					return connected, silence
+				}
+				if connected && !keepAliveRunning && d.keepAliveDue(lastKeepAlive) {
+					keepAliveRunning = true
+					lastKeepAlive = time.Now()
+					keepAlive(hub, keepAliveDone)
+				}
+			}
+
+			newHub, newCfg, err := d.checkConnection()
+			if err != nil {
+				fmt.Printf("Warning: Could not check connection settings: %v\n", err)
+				continue
+			}
+			if newHub == nil {
+				continue
+			}
+			stopEventLoop(hub, finished)
+			d.setHub(newHub, newCfg)
+			if err := d.load(); err != nil {
+				return connected, err
+			}
+			reconnected = true
+		}
+	}
+}
+
+// reportSilence logs when the hub stops or resumes sending events, silent is the state reported before.
+// Returns the current silence, nil if events are received.
+func (d *dirigeraClient) reportSilence(silent bool) *EventSilenceError {
+	d.hubMutex.Lock()
+	silence := d.silence()
+	d.hubMutex.Unlock()
+
+	switch {
+	case silence != nil && !silent:
+		fmt.Printf("Warning: Hub %s sent no events since %s\n", d.label, silence.Since.Format(time.RFC3339))
+	case silence == nil && silent:
+		fmt.Printf("Hub %s sends events again\n", d.label)
+	}
+	d.updateHealth()
+	return silence
+}
+
+func (d *dirigeraClient) resyncInterval() time.Duration {
+	d.hubMutex.Lock()
+	defer d.hubMutex.Unlock()
+
+	return d.cfg.ResyncInterval
+}
+
+// stopEventLoop stops the event loop and waits until it is finished.
+// It is stopped repeatedly because the event loop might not be running yet.
+func stopEventLoop(hub client.Client, finished chan error) {
+	for {
+		_ = hub.StopEventListening()
+		select {
+		case <-finished:
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// backoff returns the delay before the given reconnect attempt, reduced by a random jitter of up to 50 percent
+// so that several exporters do not reconnect to a restarted hub at the same time
+func backoff(attempt int) time.Duration {
+	delay := maxBackoff
+	if attempt <= 20 {
+		delay = min(initialBackoff<<(attempt-1), maxBackoff)
+	}
+	return delay/2 + rand.N(delay/2)
+}
+
+// eventLog receives the log output of the event loop of the hub client, which is the only way to learn about
+// established and failed connections. The hub client retries failed connections every 30 seconds, so failures are
+// reported to the supervisor, which reconnects with backoff instead.
+type eventLog struct {
+	connected chan struct{}
+	failed    chan struct{}
+}
+
+func (l *eventLog) Write(message []byte) (int, error) {
+	switch {
+	case strings.HasPrefix(string(message), "Error in event loop"):
+		notify(l.failed) // the supervisor logs the error
+	case strings.Contains(string(message), "Established connection"):
+		notify(l.connected)
+		fmt.Print(string(message))
+	default:
+		fmt.Print(string(message))
+	}
+	return len(message), nil
+}
+
+func notify(signal chan struct{}) {
+	select {
+	case signal <- struct{}{}:
+	default: // notification already pending
+	}
+}
+
+func drain(signal chan struct{}) {
+	select {
+	case <-signal:
+	default:
+	}
+}
@@ -0,0 +1,56 @@
+package dirigera
+
+import (
+	"fmt"
+
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
+)
+
+// FingerprintMismatchError is returned when the hub presents a certificate with another fingerprint than the
+// pinned one. It wraps ErrTLS.
+type FingerprintMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *FingerprintMismatchError) Error() string {
+	return fmt.Sprintf("TLS fingerprint mismatch: hub presented certificate with fingerprint %s, expected %s", e.Actual, e.Expected)
+}
+
+func (e *FingerprintMismatchError) Unwrap() error {
+	return ErrTLS
+}
+
+// resolveFingerprint returns the fingerprint to be used for connecting to the hub.
+// Without trust on first use, this is the configured fingerprint. Otherwise, the certificate presented by the hub
+// is verified against the fingerprint pinned in the state file. If no fingerprint is pinned yet, the presented one
+// is trusted and - if persist is set - recorded in the state file.
+func resolveFingerprint(cfg config.HubConfig, persist bool) (string, error) {
+	if !cfg.TrustOnFirstUse {
+		return cfg.TLSFingerprint, nil
+	}
+
+	store, err := loadStateStore(cfg.StateFile)
+	if err != nil {
+		return "", err
+	}
+	actual, err := fetchFingerprint(cfg.Address, cfg.Port)
+	if err != nil {
+		return "", err
+	}
+
+	pinned := store.read().TLSFingerprint
+	if pinned == "" {
+		if persist {
+			if err := store.update(func(state *hubState) { state.TLSFingerprint = actual }); err != nil {
+				return "", fmt.Errorf("error pinning TLS fingerprint: %w", err)
+			}
+			fmt.Printf("Trusting hub certificate with fingerprint %s on first use\n", actual)
+		}
+		return actual, nil
+	}
+	if pinned != actual {
+		return "", &FingerprintMismatchError{Expected: pinned, Actual: actual}
+	}
+	return pinned, nil
+}
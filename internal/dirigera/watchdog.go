@@ -0,0 +1,93 @@
+package dirigera
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/salex-org/ikea-dirigera-client/pkg/client"
+)
+
+// EventSilenceError is reported by the health check when the hub is connected but sent no events for longer than
+// the configured threshold, e.g. the hub stops delivering events over an open connection after firmware updates
+type EventSilenceError struct {
+	Since     time.Time // time of the last event or the connect if no event was received since
+	Threshold time.Duration
+}
+
+func (e *EventSilenceError) Error() string {
+	return fmt.Sprintf("no events received since %s (threshold %s)", e.Since.Format(time.RFC3339), e.Threshold)
+}
+
+// Degraded marks the error as degraded state for the ready check of the web server, the hub is still connected
+func (e *EventSilenceError) Degraded() bool {
+	return true
+}
+
+// recordEvent is registered for all events, it records the time of the last event and counts the events
+func (d *dirigeraClient) recordEvent(event client.Event) {
+	d.hubMutex.Lock()
+	d.lastEvent = time.Now()
+	d.hubMutex.Unlock()
+
+	d.metrics.exporter.countEvent(d.label, event.Type)
+}
+
+// recordConnect marks the start of the event connection, the silence is measured from it until the first event
+func (d *dirigeraClient) recordConnect() {
+	d.hubMutex.Lock()
+	defer d.hubMutex.Unlock()
+
+	d.connectedAt = time.Now()
+}
+
+// silence returns an EventSilenceError if no event was received for longer than the threshold, otherwise nil.
+// The hub must be locked.
+func (d *dirigeraClient) silence() *EventSilenceError {
+	threshold := d.cfg.EventSilenceThreshold
+	since := d.connectedAt
+	if d.lastEvent.After(since) {
+		since = d.lastEvent
+	}
+	if threshold <= 0 || since.IsZero() || time.Since(since) <= threshold {
+		return nil
+	}
+	return &EventSilenceError{Since: since, Threshold: threshold}
+}
+
+// keepAliveDue checks if the hub status should be requested, because no event was received within the keep-alive
+// interval since the last event, connect or keep-alive probe
+func (d *dirigeraClient) keepAliveDue(lastProbe time.Time) bool {
+	d.hubMutex.Lock()
+	defer d.hubMutex.Unlock()
+
+	interval := d.cfg.KeepAliveInterval
+	if interval <= 0 {
+		return false
+	}
+	last := d.connectedAt
+	for _, t := range []time.Time{d.lastEvent, lastProbe} {
+		if t.After(last) {
+			last = t
+		}
+	}
+	return time.Since(last) >= interval
+}
+
+// keepAliveEnabled checks if keep-alive probes are configured, then the event connection is also established again
+// when the hub is silent
+func (d *dirigeraClient) keepAliveEnabled() bool {
+	d.hubMutex.Lock()
+	defer d.hubMutex.Unlock()
+
+	return d.cfg.KeepAliveInterval > 0
+}
+
+// keepAlive requests the hub status in the background and sends the result to done. The probe only checks that the
+// hub is reachable over REST, a silent event connection is not detected by it. The request has no timeout, so the
+// supervisor starts no further probe until the result was received.
+func keepAlive(hub client.Client, done chan<- error) {
+	go func() {
+		_, err := hub.GetHubStatus()
+		done <- err
+	}()
+}
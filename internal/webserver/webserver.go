@@ -3,10 +3,14 @@ package webserver
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/salex-org/ikea-dirigera-exporter/internal/config"
 )
 
 type Server interface {
@@ -14,25 +18,60 @@ type Server interface {
 	Shutdown() error
 }
 
+// HealthCheck returns the errors of all unhealthy components, key: name of the component.
+// Errors with a method Pending returning true mark components still starting up, errors with a method Degraded
+// returning true mark components working with restrictions.
 type HealthCheck func() map[string]error
 
+// ReloadFunc reloads the configuration of the exporter
+type ReloadFunc func() error
+
+// ProbeFunc probes the target with the settings of the module and registers the metrics at the registerer.
+// An error wrapping ErrUnknownModule is reported as bad request, all other errors as failed probe.
+type ProbeFunc func(target, module string, registerer prometheus.Registerer) error
+
+// DefaultModule is used for probes without module parameter
+const DefaultModule = "default"
+
+var ErrUnknownModule = errors.New("unknown module")
+
 type ReadyStatus struct {
-	Message string           `json:"status"`
-	Errors  map[string]error `json:"errors"`
+	Message string                 `json:"status"`
+	Errors  map[string]ErrorStatus `json:"errors"`
+}
+
+// ErrorStatus describes the error of a component in the ready status
+type ErrorStatus struct {
+	Code    string     `json:"code"`
+	Message string     `json:"message"`
+	Since   *time.Time `json:"since,omitempty"`
+	Hint    string     `json:"hint,omitempty"`
+}
+
+// DetailedError is implemented by errors providing details for the ready status, other errors are reported with
+// the code "error"
+type DetailedError interface {
+	error
+	Code() string
+	Since() time.Time
+	Hint() string
 }
 
-func NewServer(healthCheck HealthCheck) Server {
+func NewServer(cfg config.ServerConfig, healthCheck HealthCheck, reload ReloadFunc, probe ProbeFunc) Server {
 	server := ServerImpl{
 		healthCheck: healthCheck,
+		reload:      reload,
+		probe:       probe,
 	}
-	port := 9100
 	mux := http.NewServeMux()
 	mux.HandleFunc("/metrics", promhttp.Handler().ServeHTTP)
 	mux.HandleFunc("/alive", server.handleAlive)
 	mux.HandleFunc("/ready", server.handleReady)
+	mux.HandleFunc("/-/reload", server.handleReload)
+	mux.HandleFunc("/probe", server.handleProbe)
 	mux.HandleFunc("/", server.handle404)
 	server.httpServer = http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
 		Handler: mux,
 	}
 	return &server
@@ -40,6 +79,8 @@ func NewServer(healthCheck HealthCheck) Server {
 
 type ServerImpl struct {
 	healthCheck HealthCheck
+	reload      ReloadFunc
+	probe       ProbeFunc
 	httpServer  http.Server
 }
 
@@ -65,14 +106,83 @@ func (s *ServerImpl) handleAlive(w http.ResponseWriter, r *http.Request) {
 	_, _ = fmt.Fprint(w, "alive")
 }
 
+func (s *ServerImpl) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = fmt.Fprint(w, "Only POST requests allowed")
+		return
+	}
+	if err := s.reload(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = fmt.Fprintf(w, "Error reloading configuration: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, "reloaded")
+}
+
+// handleProbe probes the hub given by the target parameter and responds with the metrics of this probe only
+func (s *ServerImpl) handleProbe(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "Parameter target missing")
+		return
+	}
+	module := r.URL.Query().Get("module")
+	if module == "" {
+		module = DefaultModule
+	}
+
+	registry := prometheus.NewRegistry()
+	successMetric := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Result of the probe (0 = failed, 1 = successful)",
+	})
+	durationMetric := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "Duration of the probe (seconds)",
+	})
+	registry.MustRegister(successMetric)
+	registry.MustRegister(durationMetric)
+
+	start := time.Now()
+	err := s.probe(target, module, registry)
+	durationMetric.Set(time.Since(start).Seconds())
+	switch {
+	case errors.Is(err, ErrUnknownModule):
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprintf(w, "%v", err)
+		return
+	case err != nil:
+		fmt.Printf("Probe of %s with module %s failed: %v\n", target, module, err)
+		successMetric.Set(0)
+	default:
+		successMetric.Set(1)
+	}
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
 func (s *ServerImpl) handleReady(w http.ResponseWriter, _ *http.Request) {
+	errs := s.healthCheck()
 	status := ReadyStatus{
-		Errors: s.healthCheck(),
+		Errors: make(map[string]ErrorStatus, len(errs)),
+	}
+	for name, err := range errs {
+		status.Errors[name] = newErrorStatus(err)
 	}
-	if len(status.Errors) == 0 {
+	switch {
+	case len(errs) == 0:
 		w.WriteHeader(http.StatusOK)
 		status.Message = "ready"
-	} else {
+	case allPending(errs):
+		w.WriteHeader(http.StatusInternalServerError)
+		status.Message = "initial sync pending"
+	case allDegraded(errs):
+		w.WriteHeader(http.StatusInternalServerError)
+		status.Message = "degraded"
+	default:
 		w.WriteHeader(http.StatusInternalServerError)
 		status.Message = "not ready"
 	}
@@ -85,3 +195,39 @@ func (s *ServerImpl) handleReady(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = fmt.Fprintf(w, "%s", string(response))
 }
+
+func newErrorStatus(err error) ErrorStatus {
+	var detailed DetailedError
+	if !errors.As(err, &detailed) {
+		return ErrorStatus{Code: "error", Message: err.Error()}
+	}
+	since := detailed.Since()
+	return ErrorStatus{
+		Code:    detailed.Code(),
+		Message: err.Error(),
+		Since:   &since,
+		Hint:    detailed.Hint(),
+	}
+}
+
+// allPending checks if all errors are reported by components still starting up
+func allPending(errs map[string]error) bool {
+	for _, err := range errs {
+		var pending interface{ Pending() bool }
+		if !errors.As(err, &pending) || !pending.Pending() {
+			return false
+		}
+	}
+	return true
+}
+
+// allDegraded checks if all errors are reported by components working with restrictions
+func allDegraded(errs map[string]error) bool {
+	for _, err := range errs {
+		var degraded interface{ Degraded() bool }
+		if !errors.As(err, &degraded) || !degraded.Degraded() {
+			return false
+		}
+	}
+	return true
+}